@@ -62,22 +62,30 @@ func init() {
 	rootCmd.PersistentFlags().BoolP("json-log", "", false, "log as json")
 
 	rootCmd.PersistentFlags().StringP("vault-role", "", "default", "Vault role")
-	rootCmd.PersistentFlags().StringP("vault-auth-path", "", "approle", "Vault auth-path, e.g. /v1/auth/<vault-auth-path>/")
+	rootCmd.PersistentFlags().StringP("vault-auth-path", "", "", "Vault auth-path, e.g. /v1/auth/<vault-auth-path>/ (defaults to each auth method's conventional mount name if unset)")
+	rootCmd.PersistentFlags().StringP("vault-auth-method", "", "approle", "Vault auth method to use (approle, kubernetes, aws, jwt, userpass)")
 	rootCmd.PersistentFlags().StringP("vault-approle-role-id", "", "", "Vault AppRole RoleID")
 	rootCmd.PersistentFlags().StringP("vault-approle-role-id-path", "", defaultVaultApproleRoleIDPath, "Vault AppRole RoleID path")
 	rootCmd.PersistentFlags().StringP("vault-approle-initial-secret-id", "", "", "Initial Vault AppRole SecretID")
 	rootCmd.PersistentFlags().StringP("vault-approle-initial-secret-id-path", "", defaultVaultApproleSecretIDStorePath, "Initial Vault AppRole SecretID path")
 	rootCmd.PersistentFlags().StringP("vault-approle-secret-id-store-path", "", defaultVaultApproleSecretIDStorePath, "Vault AppRole SecretID storage path")
+	rootCmd.PersistentFlags().StringP("vault-jwt-path", "", "", "Path to the JWT to present for JWT/OIDC authentication")
+	rootCmd.PersistentFlags().StringP("vault-userpass-username", "", "", "Username for userpass authentication")
+	rootCmd.PersistentFlags().StringP("vault-userpass-password-path", "", "", "Path to the password file for userpass authentication")
 
 	viper.BindPFlag("log-level", rootCmd.PersistentFlags().Lookup("log-level"))
 	viper.BindPFlag("json-log", rootCmd.PersistentFlags().Lookup("json-log"))
 	viper.BindPFlag("vault-role", rootCmd.PersistentFlags().Lookup("vault-role"))
 	viper.BindPFlag("vault-auth-path", rootCmd.PersistentFlags().Lookup("vault-auth-path"))
+	viper.BindPFlag("vault-auth-method", rootCmd.PersistentFlags().Lookup("vault-auth-method"))
 	viper.BindPFlag("vault-approle-role-id", rootCmd.PersistentFlags().Lookup("vault-approle-role-id"))
 	viper.BindPFlag("vault-approle-role-id-path", rootCmd.PersistentFlags().Lookup("vault-approle-role-id-path"))
 	viper.BindPFlag("vault-approle-initial-secret-id", rootCmd.PersistentFlags().Lookup("vault-approle-intitial-secret-id"))
 	viper.BindPFlag("vault-approle-initial-secret-id-path", rootCmd.PersistentFlags().Lookup("vault-approle-initial-secret-id-path"))
 	viper.BindPFlag("vault-approle-secret-id-store-path", rootCmd.PersistentFlags().Lookup("vault-approle-secret-id-store-path"))
+	viper.BindPFlag("vault-jwt-path", rootCmd.PersistentFlags().Lookup("vault-jwt-path"))
+	viper.BindPFlag("vault-userpass-username", rootCmd.PersistentFlags().Lookup("vault-userpass-username"))
+	viper.BindPFlag("vault-userpass-password-path", rootCmd.PersistentFlags().Lookup("vault-userpass-password-path"))
 }
 
 func execute() {
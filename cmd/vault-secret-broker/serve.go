@@ -15,14 +15,20 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 
+	"github.com/bedag/vault-secret-broker/pkg/broker"
 	"github.com/bedag/vault-secret-broker/pkg/vault"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var client *vault.Client
@@ -38,16 +44,51 @@ var serveCmd = &cobra.Command{
 			log.Fatal(fmt.Sprintf("Failed to initialize Vault client: %s", err.Error()))
 		}
 
-		// define api routes
-		http.HandleFunc("/", APIRoot)
+		// define api routes, each wrapped so an incoming trace context is
+		// picked up and propagated into the outbound Vault requests it triggers
+		http.Handle("/", otelhttp.NewHandler(http.HandlerFunc(APIRoot), "api-root"))
+
+		if viper.GetBool("enable-ci-handout") {
+			jobBroker, err := newBroker()
+			if err != nil {
+				log.Fatal(fmt.Sprintf("Failed to initialize secret broker: %s", err.Error()))
+			}
+			http.Handle("/v1/secret/", otelhttp.NewHandler(http.HandlerFunc(jobBroker.HandleSecret), "secret-handout"))
+		}
+
+		if metricsListen := viper.GetString("metrics-listen"); metricsListen != "" {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", promhttp.Handler())
+			go func() {
+				log.Info("Serving metrics on ", metricsListen)
+				if err := http.ListenAndServe(metricsListen, metricsMux); err != nil {
+					log.Error("metrics listener failed: ", err.Error())
+				}
+			}()
+		}
 
 		// create http(s) listener
 		var listenAddress string
 		if viper.GetBool("tls") {
 			log.Info("Creating TLS listener")
 			listenAddress = fmt.Sprint(viper.GetString("listen-ip"), ":", viper.GetInt("listen-tls-port"))
-			err := http.ListenAndServeTLS(listenAddress, viper.GetString("tls-cert"), viper.GetString("tls-key"), nil)
+
+			var holder *certHolder
+			var err error
+			if viper.GetString("tls-source") == "vault" {
+				holder, err = newVaultCertHolder(client, viper.GetString("tls-vault-pki-mount"), viper.GetString("tls-vault-pki-role"), viper.GetString("tls-vault-common-name"))
+			} else {
+				holder, err = newFileCertHolder(viper.GetString("tls-cert"), viper.GetString("tls-key"))
+			}
 			if err != nil {
+				log.Fatal(fmt.Sprintf("Failed to load TLS certificate: %s", err.Error()))
+			}
+
+			server := &http.Server{
+				Addr:      listenAddress,
+				TLSConfig: &tls.Config{GetCertificate: holder.GetCertificate},
+			}
+			if err := server.ListenAndServeTLS("", ""); err != nil {
 				log.Fatal(err.Error())
 			}
 		} else {
@@ -71,16 +112,78 @@ func init() {
 	serveCmd.Flags().StringP("tls-cert", "", "server.crt", "TLS certificate file")
 	serveCmd.Flags().StringP("tls-key", "", "server.key", "TLS private key")
 	serveCmd.Flags().BoolP("tls", "", false, "Enable TLS")
+	serveCmd.Flags().StringP("tls-source", "", "file", "Where to obtain the TLS server certificate from (file, vault)")
+	serveCmd.Flags().StringP("tls-vault-pki-mount", "", "pki", "Vault PKI secrets engine mount path used when tls-source=vault")
+	serveCmd.Flags().StringP("tls-vault-pki-role", "", "", "Vault PKI role used to issue the server certificate when tls-source=vault")
+	serveCmd.Flags().StringP("tls-vault-common-name", "", "", "Common name to request for the server certificate when tls-source=vault")
+	serveCmd.Flags().BoolP("enable-ci-handout", "", false, "Enable the CI-job-verified secret handout API at /v1/secret/ (requires a reachable policy-file and provider credentials)")
+	serveCmd.Flags().StringP("policy-file", "", "policy.yaml", "Path to the secret handout policy file")
+	serveCmd.Flags().StringP("gitlab-url", "", "https://gitlab.com", "Base URL of the GitLab instance used to verify GitLab CI jobs")
+	serveCmd.Flags().StringP("github-token", "", "", "GitHub API token used to verify GitHub Actions runs")
+	serveCmd.Flags().StringP("github-oidc-audience", "", "vault-secret-broker", "Expected \"aud\" claim of the GitHub Actions OIDC id-token")
+	serveCmd.Flags().StringP("jenkins-url", "", "", "Base URL of the Jenkins instance used to verify Jenkins jobs")
+	serveCmd.Flags().StringP("jenkins-username", "", "", "Jenkins service account username used to look up build status")
+	serveCmd.Flags().StringP("jenkins-api-token", "", "", "Jenkins service account API token used to look up build status")
+	serveCmd.Flags().StringP("audit-log-file", "", "", "Path to the audit log file (defaults to stderr)")
+	serveCmd.Flags().StringP("metrics-listen", "", "", "Address to serve Prometheus metrics on, e.g. 127.0.0.1:9090 (disabled if empty)")
 	viper.BindPFlag("listen-ip", serveCmd.Flags().Lookup("listen-ip"))
 	viper.BindPFlag("listen-port", serveCmd.Flags().Lookup("listen-port"))
 	viper.BindPFlag("listen-tls-port", serveCmd.Flags().Lookup("listen-tls-port"))
 	viper.BindPFlag("tls-cert", serveCmd.Flags().Lookup("tls-cert"))
 	viper.BindPFlag("tls-key", serveCmd.Flags().Lookup("tls-key"))
 	viper.BindPFlag("tls", serveCmd.Flags().Lookup("tls"))
+	viper.BindPFlag("tls-source", serveCmd.Flags().Lookup("tls-source"))
+	viper.BindPFlag("tls-vault-pki-mount", serveCmd.Flags().Lookup("tls-vault-pki-mount"))
+	viper.BindPFlag("tls-vault-pki-role", serveCmd.Flags().Lookup("tls-vault-pki-role"))
+	viper.BindPFlag("tls-vault-common-name", serveCmd.Flags().Lookup("tls-vault-common-name"))
+	viper.BindPFlag("enable-ci-handout", serveCmd.Flags().Lookup("enable-ci-handout"))
+	viper.BindPFlag("policy-file", serveCmd.Flags().Lookup("policy-file"))
+	viper.BindPFlag("gitlab-url", serveCmd.Flags().Lookup("gitlab-url"))
+	viper.BindPFlag("github-token", serveCmd.Flags().Lookup("github-token"))
+	viper.BindPFlag("github-oidc-audience", serveCmd.Flags().Lookup("github-oidc-audience"))
+	viper.BindPFlag("jenkins-url", serveCmd.Flags().Lookup("jenkins-url"))
+	viper.BindPFlag("jenkins-username", serveCmd.Flags().Lookup("jenkins-username"))
+	viper.BindPFlag("jenkins-api-token", serveCmd.Flags().Lookup("jenkins-api-token"))
+	viper.BindPFlag("audit-log-file", serveCmd.Flags().Lookup("audit-log-file"))
+	viper.BindPFlag("metrics-listen", serveCmd.Flags().Lookup("metrics-listen"))
 
 	rootCmd.AddCommand(serveCmd)
 }
 
+// newBroker assembles a *broker.Broker from the "policy-file", "gitlab-url",
+// "github-token", "jenkins-url" and "audit-log-file" configuration values.
+func newBroker() (*broker.Broker, error) {
+	policy, err := broker.LoadPolicy(viper.GetString("policy-file"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy file: %s", err)
+	}
+
+	githubVerifier, err := broker.NewGitHubVerifier(context.Background(), viper.GetString("github-token"), viper.GetString("github-oidc-audience"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize GitHub Actions verifier: %s", err)
+	}
+
+	auditOut := os.Stderr
+	if logFile := viper.GetString("audit-log-file"); logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log file: %s", err)
+		}
+		auditOut = f
+	}
+
+	return &broker.Broker{
+		Logical: client.RawClient().Logical(),
+		Verifiers: map[string]broker.JobVerifier{
+			"gitlab":  broker.NewGitLabVerifier(viper.GetString("gitlab-url")),
+			"github":  githubVerifier,
+			"jenkins": broker.NewJenkinsVerifier(viper.GetString("jenkins-url"), viper.GetString("jenkins-username"), viper.GetString("jenkins-api-token")),
+		},
+		Policy: policy,
+		Audit:  broker.NewAuditLog(auditOut),
+	}, nil
+}
+
 // APIRoot is the request handler for requests to "/"
 // Currently only returns the app name and the version
 func APIRoot(w http.ResponseWriter, r *http.Request) {
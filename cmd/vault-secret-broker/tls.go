@@ -0,0 +1,188 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bedag/vault-secret-broker/pkg/vault"
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/fsnotify.v1"
+)
+
+// certHolder serves the most recently loaded server certificate to the TLS
+// handshake via GetCertificate, so rotating the underlying cert/key files
+// (e.g. by cert-manager) or re-issuing a Vault PKI certificate never
+// requires a server restart.
+type certHolder struct {
+	current atomic.Value // *tls.Certificate
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (h *certHolder) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := h.current.Load().(*tls.Certificate)
+	if cert == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded yet")
+	}
+	return cert, nil
+}
+
+func (h *certHolder) set(cert tls.Certificate) {
+	h.current.Store(&cert)
+}
+
+// newFileCertHolder loads certPath/keyPath and keeps reloading them
+// whenever they (or, for Kubernetes Secret mounts, their containing
+// "..data" symlink) change on disk, or a SIGHUP is received.
+func newFileCertHolder(certPath, keyPath string) (*certHolder, error) {
+	holder := &certHolder{}
+
+	reload := func() error {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return err
+		}
+		holder.set(cert)
+		return nil
+	}
+
+	if err := reload(); err != nil {
+		return nil, err
+	}
+
+	reloadAndLog := func() {
+		if err := reload(); err != nil {
+			log.Error("failed to reload TLS certificate: ", err.Error())
+		} else {
+			log.Info("TLS certificate reloaded")
+		}
+	}
+
+	if watch, err := fsnotify.NewWatcher(); err == nil {
+		certDir, _ := filepath.Split(filepath.Clean(certPath))
+		keyDir, _ := filepath.Split(filepath.Clean(keyPath))
+		_ = watch.Add(certDir)
+		if keyDir != certDir {
+			_ = watch.Add(keyDir)
+		}
+
+		go func() {
+			for {
+				select {
+				case event := <-watch.Events:
+					name := filepath.Clean(event.Name)
+					relevant := name == filepath.Clean(certPath) || name == filepath.Clean(keyPath) || filepath.Base(event.Name) == "..data"
+					if relevant && (event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create) {
+						reloadAndLog()
+					}
+				case err := <-watch.Errors:
+					log.Error("TLS watcher error: ", err.Error())
+				}
+			}
+		}()
+	} else {
+		log.Warn("failed to start TLS file watcher, certificate will only reload on SIGHUP: ", err.Error())
+	}
+
+	watchSIGHUP(reloadAndLog)
+
+	return holder, nil
+}
+
+// newVaultCertHolder requests a server certificate from a Vault PKI role
+// at startup and re-issues it shortly before expiry via a vault.LeaseManager,
+// closing the loop so the broker itself dogfoods Vault-issued certificates.
+func newVaultCertHolder(client *vault.Client, pkiMount, pkiRole, commonName string) (*certHolder, error) {
+	holder := &certHolder{}
+	logical := client.RawClient().Logical()
+
+	issue := func() (*vaultapi.Secret, error) {
+		secret, err := logical.Write(fmt.Sprintf("%s/issue/%s", pkiMount, pkiRole), map[string]interface{}{
+			"common_name": commonName,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		certPEM, _ := secret.Data["certificate"].(string)
+		keyPEM, _ := secret.Data["private_key"].(string)
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Vault-issued certificate: %s", err)
+		}
+
+		holder.set(cert)
+		// PKI certificates cannot be renewed into new key material, so
+		// mark the lease non-renewable: the LeaseManager will always
+		// call this Refresher again instead of renewing the old lease.
+		secret.Renewable = false
+
+		return secret, nil
+	}
+
+	secret, err := issue()
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue initial server certificate from Vault PKI: %s", err)
+	}
+
+	leases := vault.NewLeaseManager(client.RawClient())
+	if err := leases.AddLease(secret, time.Duration(secret.LeaseDuration)*time.Second, issue); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for event := range leases.Events() {
+			switch event.Type {
+			case vault.EventRefreshed:
+				log.Info("Vault PKI server certificate re-issued")
+			case vault.EventRefreshFailed:
+				log.Error("Failed to re-issue Vault PKI server certificate: ", event.Err)
+			}
+		}
+	}()
+	go leases.Run()
+
+	watchSIGHUP(func() {
+		if _, err := issue(); err != nil {
+			log.Error("failed to re-issue Vault PKI server certificate on SIGHUP: ", err.Error())
+		} else {
+			log.Info("Vault PKI server certificate re-issued")
+		}
+	})
+
+	return holder, nil
+}
+
+// watchSIGHUP invokes reload every time the process receives a SIGHUP, so
+// operators can force a TLS reload without waiting for the fsnotify/PKI
+// based automatic reload.
+func watchSIGHUP(reload func()) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		for range sighup {
+			reload()
+		}
+	}()
+}
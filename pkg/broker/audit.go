@@ -0,0 +1,59 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a single structured record of a secret handout decision.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Repo     string    `json:"repo,omitempty"`
+	Ref      string    `json:"ref,omitempty"`
+	JobName  string    `json:"job_name,omitempty"`
+	Path     string    `json:"path"`
+	Allowed  bool      `json:"allowed"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// AuditLog writes one JSON object per line for every secret handout
+// decision the Broker makes, successful or not.
+type AuditLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewAuditLog creates an AuditLog writing to out.
+func NewAuditLog(out io.Writer) *AuditLog {
+	return &AuditLog{out: out}
+}
+
+// Record appends entry to the audit log.
+func (a *AuditLog) Record(entry AuditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, _ = a.out.Write(data)
+}
@@ -0,0 +1,151 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bedag/vault-secret-broker/pkg/vault/metrics"
+	vaultapi "github.com/hashicorp/vault/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// secretPathPrefix is the route prefix HandleSecret is registered under;
+// everything after it is the Vault path to read.
+const secretPathPrefix = "/v1/secret/"
+
+// Broker dispatches CI-job-verified secret requests to Vault. A secret is
+// only proxied once the requesting job has been confirmed, via the
+// provider-specific JobVerifier, to be currently running, and the verified
+// identity is authorized for the requested path by Policy.
+type Broker struct {
+	Logical   *vaultapi.Logical
+	Verifiers map[string]JobVerifier
+	Policy    *Policy
+	Audit     *AuditLog
+}
+
+// secretRequest is the JSON body POSTed to /v1/secret/{path}.
+type secretRequest struct {
+	JobID    string `json:"job_id"`
+	JobToken string `json:"job_token"`
+	Provider string `json:"provider"`
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code written,
+// so it can be attached as a label on BrokerRequestDuration.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// HandleSecret implements POST /v1/secret/{path}: it verifies the caller's
+// job claim, checks the resulting identity against Policy, and - only if
+// both succeed - reads and returns the requested Vault path.
+func (b *Broker) HandleSecret(w http.ResponseWriter, r *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	defer func() {
+		metrics.BrokerRequestDuration.WithLabelValues(secretPathPrefix, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	}()
+	w = recorder
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secretPath := strings.TrimPrefix(r.URL.Path, secretPathPrefix)
+	if secretPath == "" {
+		http.Error(w, "missing secret path", http.StatusBadRequest)
+		return
+	}
+
+	var req secretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	verifier, ok := b.Verifiers[req.Provider]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown provider %q", req.Provider), http.StatusBadRequest)
+		return
+	}
+
+	claim := JobClaim{Provider: req.Provider, JobID: req.JobID, JobToken: req.JobToken}
+	identity, err := verifier.Verify(r.Context(), claim)
+	if err != nil {
+		log.Warn("job verification failed: ", err.Error())
+		b.audit(identity, secretPath, false, err)
+		http.Error(w, "job verification failed", http.StatusForbidden)
+		return
+	}
+
+	if !b.Policy.Allows(identity, secretPath) {
+		err := fmt.Errorf("path %q not permitted by policy for %+v", secretPath, identity)
+		b.audit(identity, secretPath, false, err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	secret, err := b.Logical.ReadWithContext(r.Context(), secretPath)
+	if err != nil {
+		b.audit(identity, secretPath, false, err)
+		http.Error(w, "failed to read secret", http.StatusInternalServerError)
+		return
+	}
+	if secret == nil {
+		b.audit(identity, secretPath, false, fmt.Errorf("no secret found at %q", secretPath))
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	b.audit(identity, secretPath, true, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(secret.Data)
+}
+
+func (b *Broker) audit(identity JobIdentity, secretPath string, allowed bool, err error) {
+	if b.Audit == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:     time.Now(),
+		Provider: identity.Provider,
+		Repo:     identity.Repo,
+		Ref:      identity.Ref,
+		JobName:  identity.JobName,
+		Path:     secretPath,
+		Allowed:  allowed,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	b.Audit.Record(entry)
+}
@@ -0,0 +1,91 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"io/ioutil"
+	"path"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PolicyRule authorizes jobs matching (Provider, Repo, Ref, JobName) - each
+// of which may be "*" or empty to mean "any" - to read Vault paths matching
+// one of Paths. Paths are matched with path.Match, so "*" only matches
+// within a single path segment; use multiple entries for deeper subtrees.
+type PolicyRule struct {
+	Provider string   `yaml:"provider"`
+	Repo     string   `yaml:"repo"`
+	Ref      string   `yaml:"ref"`
+	JobName  string   `yaml:"job_name"`
+	Paths    []string `yaml:"paths"`
+}
+
+// Policy is the full set of rules mapping verified job identities to the
+// Vault paths they may read.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// LoadPolicy reads and parses a policy file from disk.
+func LoadPolicy(filePath string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// Allows reports whether identity is authorized to read secretPath under
+// any rule in the policy.
+func (p *Policy) Allows(identity JobIdentity, secretPath string) bool {
+	for _, rule := range p.Rules {
+		if !globMatch(rule.Provider, identity.Provider) {
+			continue
+		}
+		if !globMatch(rule.Repo, identity.Repo) {
+			continue
+		}
+		if !globMatch(rule.Ref, identity.Ref) {
+			continue
+		}
+		if !globMatch(rule.JobName, identity.JobName) {
+			continue
+		}
+
+		for _, pathGlob := range rule.Paths {
+			if ok, _ := path.Match(pathGlob, secretPath); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
@@ -0,0 +1,46 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package broker implements the CI-job-verified secret handout API: it
+// confirms a secret request came from a currently running CI job before
+// proxying the requested path to Vault.
+package broker
+
+import "context"
+
+// JobClaim identifies the CI job that is requesting a secret handout, as
+// presented by the caller in the broker API request body.
+type JobClaim struct {
+	Provider string
+	JobID    string
+	JobToken string
+}
+
+// JobIdentity is the verified identity of a currently running CI job, as
+// established by a JobVerifier. It is what Policy rules are matched
+// against and what gets written to the audit log.
+type JobIdentity struct {
+	Provider string
+	Repo     string
+	Ref      string
+	JobName  string
+}
+
+// JobVerifier confirms that a JobClaim corresponds to a CI job that is
+// actually running right now, returning its verified JobIdentity. A
+// JobVerifier must reject claims for jobs that have already finished,
+// since a leaked job token would otherwise remain usable indefinitely.
+type JobVerifier interface {
+	Verify(ctx context.Context, claim JobClaim) (JobIdentity, error)
+}
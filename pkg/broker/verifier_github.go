@@ -0,0 +1,149 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// githubActionsIssuer is the fixed OIDC issuer GitHub Actions mints
+// workflow id-tokens from.
+const githubActionsIssuer = "https://token.actions.githubusercontent.com"
+
+// GitHubVerifier verifies GitHub Actions job claims in two steps: it first
+// validates the workflow's OIDC id-token against GitHub's published JWKS,
+// then double-checks via the REST API that the run the token was minted
+// for is still in progress - a JWT alone only proves the run existed when
+// it was issued, not that it's still running.
+// See https://docs.github.com/en/actions/deployment/security-hardening-your-deployments/about-security-hardening-with-openid-connect
+type GitHubVerifier struct {
+	verifier   *oidc.IDTokenVerifier
+	httpClient *http.Client
+	apiBaseURL string
+	// apiToken is a GitHub token with read access to Actions runs, used to
+	// look up the run's current status.
+	apiToken string
+	// audience is the expected "aud" claim of the workflow id-token, as
+	// configured on the "id-token" permission / the audience argument to
+	// "actions/github-script"'s getIDToken. Id-tokens minted for any other
+	// audience (e.g. a different relying party such as AWS) are rejected.
+	audience string
+}
+
+// NewGitHubVerifier creates a GitHubVerifier. apiToken needs at least
+// "actions:read" on the repositories whose jobs are verified. audience must
+// match the "aud" claim the workflow requested its id-token for; see
+// https://docs.github.com/en/actions/deployment/security-hardening-your-deployments/about-security-hardening-with-openid-connect.
+func NewGitHubVerifier(ctx context.Context, apiToken, audience string) (*GitHubVerifier, error) {
+	if audience == "" {
+		return nil, fmt.Errorf("github oidc audience must not be empty")
+	}
+
+	provider, err := oidc.NewProvider(ctx, githubActionsIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GitHub Actions OIDC provider: %s", err)
+	}
+
+	return &GitHubVerifier{
+		// audience validation is done manually in Verify so the error
+		// message can name the expected audience explicitly.
+		verifier:   provider.Verifier(&oidc.Config{SkipClientIDCheck: true}),
+		httpClient: http.DefaultClient,
+		apiBaseURL: "https://api.github.com",
+		apiToken:   apiToken,
+		audience:   audience,
+	}, nil
+}
+
+type githubActionsClaims struct {
+	Repository string `json:"repository"`
+	RunID      string `json:"run_id"`
+	Ref        string `json:"ref"`
+	Workflow   string `json:"workflow"`
+}
+
+// Verify implements JobVerifier.
+func (v *GitHubVerifier) Verify(ctx context.Context, claim JobClaim) (JobIdentity, error) {
+	idToken, err := v.verifier.Verify(ctx, claim.JobToken)
+	if err != nil {
+		return JobIdentity{}, fmt.Errorf("invalid GitHub Actions OIDC token: %s", err)
+	}
+
+	if !audienceContains(idToken.Audience, v.audience) {
+		return JobIdentity{}, fmt.Errorf("github actions id-token audience %v does not include %q", idToken.Audience, v.audience)
+	}
+
+	var claims githubActionsClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return JobIdentity{}, err
+	}
+
+	running, err := v.runInProgress(ctx, claims.Repository, claims.RunID)
+	if err != nil {
+		return JobIdentity{}, err
+	}
+	if !running {
+		return JobIdentity{}, fmt.Errorf("github actions run %s on %s is not in progress", claims.RunID, claims.Repository)
+	}
+
+	return JobIdentity{
+		Provider: "github",
+		Repo:     claims.Repository,
+		Ref:      claims.Ref,
+		JobName:  claims.Workflow,
+	}, nil
+}
+
+func audienceContains(audience []string, want string) bool {
+	for _, aud := range audience {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *GitHubVerifier) runInProgress(ctx context.Context, repo, runID string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/repos/%s/actions/runs/%s", v.apiBaseURL, repo, runID), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+v.apiToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("github actions run lookup failed with status %d", resp.StatusCode)
+	}
+
+	var run struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return false, err
+	}
+
+	return run.Status == "in_progress" || run.Status == "queued", nil
+}
@@ -0,0 +1,87 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// GitLabVerifier verifies GitLab CI job claims by calling the "current
+// job" endpoint with the job's own CI_JOB_TOKEN. GitLab only answers this
+// call while the job is actually running, so a successful response is
+// itself proof the job hasn't finished yet.
+// See https://docs.gitlab.com/ee/api/jobs.html#get-job-tokens-job
+type GitLabVerifier struct {
+	// BaseURL is the GitLab instance's API base, e.g. "https://gitlab.example.com".
+	BaseURL string
+
+	HTTPClient *http.Client
+}
+
+// NewGitLabVerifier creates a GitLabVerifier against the given GitLab instance.
+func NewGitLabVerifier(baseURL string) *GitLabVerifier {
+	return &GitLabVerifier{BaseURL: baseURL, HTTPClient: http.DefaultClient}
+}
+
+type gitlabJobResponse struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Ref      string `json:"ref"`
+	Status   string `json:"status"`
+	Pipeline struct {
+		ProjectID int `json:"project_id"`
+	} `json:"pipeline"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+// Verify implements JobVerifier.
+func (v *GitLabVerifier) Verify(ctx context.Context, claim JobClaim) (JobIdentity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/v4/job", v.BaseURL), nil)
+	if err != nil {
+		return JobIdentity{}, err
+	}
+	req.Header.Set("JOB-TOKEN", claim.JobToken)
+
+	resp, err := v.HTTPClient.Do(req)
+	if err != nil {
+		return JobIdentity{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return JobIdentity{}, fmt.Errorf("gitlab job lookup failed with status %d", resp.StatusCode)
+	}
+
+	var job gitlabJobResponse
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return JobIdentity{}, err
+	}
+
+	if job.Status != "running" {
+		return JobIdentity{}, fmt.Errorf("gitlab job %d is not running (status=%s)", job.ID, job.Status)
+	}
+
+	return JobIdentity{
+		Provider: "gitlab",
+		Repo:     job.Project.PathWithNamespace,
+		Ref:      job.Ref,
+		JobName:  job.Name,
+	}, nil
+}
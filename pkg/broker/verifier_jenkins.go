@@ -0,0 +1,142 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// buildTokenParameterName is the build parameter a Jenkinsfile is expected
+// to set to a freshly generated random value (e.g. "${UUID.randomUUID()}")
+// and pass through unchanged as claim.JobToken. Comparing the two is what
+// binds the credential to this specific build: reading a build's own
+// parameters requires the service account below, which callers never hold,
+// so only the pipeline that minted the value (and whoever it handed the
+// value to) can produce a matching claim.JobToken for that job_id.
+const buildTokenParameterName = "VAULT_SECRET_BROKER_BUILD_TOKEN"
+
+// JenkinsVerifier verifies Jenkins job claims against the Jenkins JSON API.
+// It authenticates with a fixed service account (Username/APIToken) rather
+// than a caller-supplied credential - a caller-supplied credential only
+// proves "this caller can read some job", not that the caller is the build
+// named in claim.JobID, since Jenkins' "?token=" remote-access parameter is
+// only enforced on the "/build" and "/buildWithParameters" trigger
+// endpoints and silently ignored on reads like "api/json". Instead, the
+// build itself is required to be parameterized with
+// buildTokenParameterName set to a secret value, and claim.JobToken must
+// match that parameter's current value for the specific build named in
+// claim.JobID - binding the credential to that exact build.
+//
+// claim.JobID is expected to be "<job-path>#<build-number>", e.g.
+// "folder/my-pipeline#42".
+type JenkinsVerifier struct {
+	// BaseURL is the Jenkins instance's base, e.g. "https://ci.example.com".
+	BaseURL string
+
+	// Username and APIToken authenticate the broker's own Jenkins service
+	// account, used to look up build status and parameters.
+	Username string
+	APIToken string
+
+	HTTPClient *http.Client
+}
+
+// NewJenkinsVerifier creates a JenkinsVerifier against the given Jenkins
+// instance, authenticating lookups with username/apiToken.
+func NewJenkinsVerifier(baseURL, username, apiToken string) *JenkinsVerifier {
+	return &JenkinsVerifier{BaseURL: baseURL, Username: username, APIToken: apiToken, HTTPClient: http.DefaultClient}
+}
+
+type jenkinsBuildResponse struct {
+	Building bool                 `json:"building"`
+	FullName string               `json:"fullDisplayName"`
+	Actions  []jenkinsBuildAction `json:"actions"`
+}
+
+type jenkinsBuildAction struct {
+	Parameters []jenkinsBuildParameter `json:"parameters"`
+}
+
+type jenkinsBuildParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Verify implements JobVerifier.
+func (v *JenkinsVerifier) Verify(ctx context.Context, claim JobClaim) (JobIdentity, error) {
+	jobPath, buildNumber, err := splitJenkinsJobID(claim.JobID)
+	if err != nil {
+		return JobIdentity{}, err
+	}
+
+	// Jenkins addresses a job inside folders as /job/<folder>/job/<name>
+	apiURL := fmt.Sprintf("%s/job/%s/%s/api/json", v.BaseURL, strings.ReplaceAll(jobPath, "/", "/job/"), buildNumber)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return JobIdentity{}, err
+	}
+	req.SetBasicAuth(v.Username, v.APIToken)
+
+	resp, err := v.HTTPClient.Do(req)
+	if err != nil {
+		return JobIdentity{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return JobIdentity{}, fmt.Errorf("jenkins build lookup failed with status %d", resp.StatusCode)
+	}
+
+	var build jenkinsBuildResponse
+	if err := json.NewDecoder(resp.Body).Decode(&build); err != nil {
+		return JobIdentity{}, err
+	}
+
+	buildToken, ok := jenkinsBuildParameterValue(build, buildTokenParameterName)
+	if !ok || buildToken == "" || buildToken != claim.JobToken {
+		return JobIdentity{}, fmt.Errorf("job token does not match the %q build parameter of %s", buildTokenParameterName, claim.JobID)
+	}
+
+	if !build.Building {
+		return JobIdentity{}, fmt.Errorf("jenkins build %s is not currently building", claim.JobID)
+	}
+
+	return JobIdentity{Provider: "jenkins", Repo: jobPath, JobName: build.FullName}, nil
+}
+
+func jenkinsBuildParameterValue(build jenkinsBuildResponse, name string) (string, bool) {
+	for _, action := range build.Actions {
+		for _, param := range action.Parameters {
+			if param.Name == name {
+				return param.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+func splitJenkinsJobID(jobID string) (jobPath string, buildNumber string, err error) {
+	for i := len(jobID) - 1; i >= 0; i-- {
+		if jobID[i] == '#' {
+			return jobID[:i], jobID[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid jenkins job id %q, expected \"<job-path>#<build-number>\"", jobID)
+}
@@ -15,28 +15,46 @@
 package vault
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"time"
 
+	"github.com/bedag/vault-secret-broker/pkg/vault/metrics"
 	vaultapi "github.com/hashicorp/vault/api"
+	approleauth "github.com/hashicorp/vault/api/auth/approle"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-// Approle implements an opinionated Vault AppRole authentication
-// based on single use SecredIDs
+// defaultApproleMountPath is Vault's conventional mount path for the
+// AppRole auth method, used when "vault-auth-path" is left unset.
+const defaultApproleMountPath = "approle"
+
+// Approle implements Vault AppRole authentication on top of the upstream
+// github.com/hashicorp/vault/api/auth/approle helper instead of hand-rolling
+// the login/secret-id dance. On top of what the helper provides it rotates
+// the SecretID after every successful login and persists the new value via
+// a SecretIDStore, and it transparently unwraps a response-wrapped SecretID
+// (as produced by e.g. "vault write -wrap-ttl=60s auth/approle/role/.../secret-id")
+// before using it, falling back to treating the value as a raw SecretID
+// when it isn't a wrapping token.
 // See https://www.vaultproject.io/api/auth/approle/index.html
 type Approle struct {
 	roleID   string
 	secretID string
-	// the auth token retrieved by authenticating with the roleID/secretID
-	token string
-	// the persistent storage path for the SecretID
-	// new SecretIDs generated during the auth refresh process will be
-	// stored here
-	secretIDStorePath string
-
-	// true if the current SecredID has been persisted to disk
+
+	// the auth mount path and role used for login, e.g. "approle" and "default"
+	mountPath string
+	role      string
+
+	// store persists the rotated SecretID between logins. Defaults to a
+	// hardened FileSecretIDStore, but can be swapped via
+	// ApproleSecretIDStoreOption for e.g. a memory-only, age-encrypted or
+	// Kubernetes-Secret-backed store.
+	store SecretIDStore
+
+	// true if the current SecredID has been persisted via store
 	// false if not
 	persisted bool
 }
@@ -48,6 +66,9 @@ type approleOptions struct {
 	initialSecretID     string
 	initialSecretIDPath string
 	secretIDStorePath   string
+	store               SecretIDStore
+	mountPath           string
+	role                string
 }
 
 // ApproleOption configures a Vault client using the functional options paradigm popularized by Rob Pike and Dave Cheney.
@@ -90,7 +111,41 @@ func (co ApproleInitialSecretIDPath) apply(o *approleOptions) {
 type ApproleSecretIDStorePath string
 
 func (co ApproleSecretIDStorePath) apply(o *approleOptions) {
-	o.initialSecretIDPath = string(co)
+	o.secretIDStorePath = string(co)
+}
+
+// approleSecretIDStoreOption wraps a SecretIDStore so it can be passed to
+// NewApproleWithOptions via ApproleSecretIDStoreOption.
+type approleSecretIDStoreOption struct {
+	store SecretIDStore
+}
+
+func (co approleSecretIDStoreOption) apply(o *approleOptions) {
+	o.store = co.store
+}
+
+// ApproleSecretIDStoreOption selects the SecretIDStore used to load and
+// persist the rotated SecretID, overriding the "vault-approle-secretid-store-path"
+// based hardened file store default. Pass a memory-only store for
+// ephemeral pods authenticating primarily via Kubernetes auth, an
+// age-encrypted file store, or a Kubernetes-Secret-backed store shared by
+// multiple broker replicas.
+func ApproleSecretIDStoreOption(store SecretIDStore) ApproleOption {
+	return approleSecretIDStoreOption{store: store}
+}
+
+// ApproleMountPath is the mount path of the AppRole auth method, e.g. "approle"
+type ApproleMountPath string
+
+func (co ApproleMountPath) apply(o *approleOptions) {
+	o.mountPath = string(co)
+}
+
+// ApproleRole is the name of the AppRole role to authenticate as
+type ApproleRole string
+
+func (co ApproleRole) apply(o *approleOptions) {
+	o.role = string(co)
 }
 
 // NewApprole creates a new AppRole.
@@ -126,6 +181,18 @@ func NewApproleWithOptions(opts ...ApproleOption) (*Approle, error) {
 		o.secretIDStorePath = viper.GetString("vault-approle-secretid-store-path")
 	}
 
+	if o.mountPath == "" {
+		o.mountPath = viper.GetString("vault-auth-path")
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = defaultApproleMountPath
+	}
+
+	if o.role == "" {
+		o.role = viper.GetString("vault-role")
+	}
+
 	// Get the RoleID ...
 	// ... from cli parameters or the environment
 	if o.roleID == "" {
@@ -146,12 +213,17 @@ func NewApproleWithOptions(opts ...ApproleOption) (*Approle, error) {
 		return nil, fmt.Errorf("failed to retrieve the AppRole RoleID")
 	}
 
+	store := o.store
+	if store == nil {
+		store = NewFileSecretIDStore(o.secretIDStorePath)
+	}
+
 	// Get the initial SecretID
 	// ... from the persistent SecretID store
 	if o.initialSecretID == "" {
-		secretID, err := ioutil.ReadFile(o.secretIDStorePath)
+		secretID, err := store.Load()
 		if err == nil {
-			o.initialSecretID = string(secretID)
+			o.initialSecretID = secretID
 		}
 	}
 
@@ -174,49 +246,93 @@ func NewApproleWithOptions(opts ...ApproleOption) (*Approle, error) {
 		return nil, fmt.Errorf("failed to retrieve the initial AppRole SecretID")
 	}
 
-	approle := &Approle{roleID: o.roleID, secretIDStorePath: o.secretIDStorePath}
+	approle := &Approle{roleID: o.roleID, store: store, mountPath: o.mountPath, role: o.role}
 	approle.SetSecretID(o.initialSecretID)
 
 	return approle, nil
 }
 
-// Login with AppRole authentication at the given authentication path (/auth/<authPath>/login) and the given client
-// As this Approle type is build around the idea that the SecretID is single use only,
-// the Login method also tries to retrieve and store a new SecretID and destroys the old one
-func (approle *Approle) Login(rawClient *vaultapi.Client, authPath string, role string) (*vaultapi.Secret, error) {
-	payload := map[string]interface{}{"role_id": approle.roleID, "secret_id": approle.secretID}
-	logical := rawClient.Logical()
+// Login authenticates against the AppRole auth method mounted at
+// approle.mountPath using approle.role, transparently unwrapping the
+// SecretID first if it turns out to be a response-wrapping token. A
+// fresh SecretID is requested and persisted afterwards, and the one just
+// consumed is destroyed so it cannot be replayed.
+func (approle *Approle) Login(ctx context.Context, client *vaultapi.Client) (*vaultapi.Secret, error) {
+	start := time.Now()
+	tokenSecret, err := approle.login(ctx, client)
+	metrics.AppRoleLoginDuration.Observe(time.Since(start).Seconds())
+	metrics.AppRoleLogins.WithLabelValues(metrics.Outcome(err)).Inc()
+	return tokenSecret, err
+}
 
-	// perform the login
-	tokenSecret, err := logical.Write(fmt.Sprintf("auth/%s/login", authPath), payload)
+func (approle *Approle) login(ctx context.Context, client *vaultapi.Client) (*vaultapi.Secret, error) {
+	secretID, err := approle.unwrapSecretIDIfWrapped(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap AppRole SecretID: %s", err)
+	}
+
+	auth, err := approleauth.NewAppRoleAuth(
+		approle.roleID,
+		&approleauth.SecretID{FromString: secretID},
+		approleauth.WithMountPath(approle.mountPath),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build AppRole authenticator: %s", err)
+	}
+
+	tokenSecret, err := auth.Login(ctx, client)
 	if err != nil {
 		return nil, err
 	}
 
-	// store the token and make it the active auth token for the client as
-	// we need to create a new secret id in the next step which requires
-	// authenticated requests
-	approle.token = string(tokenSecret.Auth.ClientToken)
-	rawClient.SetToken(approle.token)
+	// make the new token the client's active token, as requesting a new
+	// SecretID in the next step requires an authenticated request
+	client.SetToken(tokenSecret.Auth.ClientToken)
 
-	payload = map[string]interface{}{}
-	secretIDSecret, err := logical.Write(fmt.Sprintf("auth/%s/role/%s/secret-id", authPath, role), payload)
+	// rotate the SecretID: request a new one and destroy the one we just used
+	logical := client.Logical()
+	secretIDSecret, err := logical.Write(fmt.Sprintf("auth/%s/role/%s/secret-id", approle.mountPath, approle.role), map[string]interface{}{})
 	// failing to generate a new the secret id is bad but not immediately fatal
 	// so we do return the token secret with the error and hope for the best
 	if err != nil {
+		metrics.SecretIDRotations.WithLabelValues(metrics.OutcomeFailure).Inc()
 		return tokenSecret, err
 	}
-	oldSecretID := approle.secretID
+	oldSecretID := secretID
 	approle.SetSecretID(secretIDSecret.Data["secret_id"].(string))
+	metrics.SecretIDRotations.WithLabelValues(metrics.OutcomeSuccess).Inc()
 
 	// Enforce single use SecretIDs by destroying the old SecretID
-	payload = map[string]interface{}{"secret_id": oldSecretID}
-	_, err = logical.Write(fmt.Sprintf("auth/%s/role/%s/secret-id/destroy", authPath, role), payload)
+	_, err = logical.Write(fmt.Sprintf("auth/%s/role/%s/secret-id/destroy", approle.mountPath, approle.role), map[string]interface{}{"secret_id": oldSecretID})
 	if err != nil {
 		log.Warn(fmt.Sprintf("Failed to destroy old SecretID: %s", err.Error()))
 	}
 
-	return tokenSecret, err
+	return tokenSecret, nil
+}
+
+// unwrapSecretIDIfWrapped checks whether approle.secretID is itself a
+// response-wrapping token (by looking it up via sys/wrapping/lookup) and,
+// if so, unwraps it to obtain the real SecretID. Plain, non-wrapped
+// SecretIDs are returned unchanged.
+func (approle *Approle) unwrapSecretIDIfWrapped(client *vaultapi.Client) (string, error) {
+	_, err := client.Logical().Write("sys/wrapping/lookup", map[string]interface{}{"token": approle.secretID})
+	if err != nil {
+		// not a wrapping token (or already unwrapped) - use as-is
+		return approle.secretID, nil
+	}
+
+	unwrapped, err := client.Logical().Unwrap(approle.secretID)
+	if err != nil {
+		return "", err
+	}
+
+	secretID, ok := unwrapped.Data["secret_id"].(string)
+	if !ok {
+		return "", fmt.Errorf("wrapped response did not contain a secret_id")
+	}
+
+	return secretID, nil
 }
 
 // SetSecretID sets the secret id for the approle and tries to persist it to disk
@@ -231,7 +347,7 @@ func (approle *Approle) SetSecretID(secretID string) {
 // field. Failing to persist the SecretID is not fatal as the in
 // memory one can still be used
 func (approle *Approle) Persist() {
-	err := ioutil.WriteFile(approle.secretIDStorePath, []byte(approle.secretID), 0600)
+	err := approle.store.Store(approle.secretID)
 	if err != nil {
 		log.Warn(fmt.Sprintf("Failed to persist SecretID: %s", err.Error()))
 		return
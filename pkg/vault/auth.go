@@ -0,0 +1,67 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// Authenticator performs a Vault login and returns the resulting auth
+// secret. Implementations wrap the various Vault auth methods (AppRole,
+// Kubernetes, AWS IAM, JWT/OIDC, userpass, ...) behind a common interface
+// so the Client does not need to know which one is actually in use.
+//
+// Login may be called repeatedly over the lifetime of a Client, once for
+// the initial authentication and again every time the previous token can
+// no longer be renewed.
+type Authenticator interface {
+	Login(ctx context.Context, client *vaultapi.Client) (*vaultapi.Secret, error)
+}
+
+// defaultAuthMethod is used when "vault-auth-method" is left unset.
+const defaultAuthMethod = "approle"
+
+// newDefaultAuthenticator builds the Authenticator selected by the
+// "vault-auth-method" configuration value ("approle", "kubernetes", "aws",
+// "jwt" or "userpass"), falling back to AppRole for backwards compatibility
+// with deployments that never set the option.
+func newDefaultAuthenticator(method string) (Authenticator, error) {
+	if method == "" {
+		method = defaultAuthMethod
+	}
+
+	switch method {
+	case "approle":
+		return NewApprole()
+	case "kubernetes":
+		return NewKubernetesAuthWithOptions()
+	case "aws":
+		return NewAWSAuthWithOptions()
+	case "jwt":
+		return NewJWTAuthWithOptions()
+	case "userpass":
+		return NewUserpassAuthWithOptions()
+	default:
+		return nil, unknownAuthMethodError(method)
+	}
+}
+
+type unknownAuthMethodError string
+
+func (e unknownAuthMethodError) Error() string {
+	return "unknown vault-auth-method: " + string(e)
+}
@@ -0,0 +1,106 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	awsauth "github.com/hashicorp/vault/api/auth/aws"
+	"github.com/spf13/viper"
+)
+
+// defaultAWSMountPath is Vault's conventional mount path for the AWS auth
+// method, used when "vault-auth-path" is left unset.
+const defaultAWSMountPath = "aws"
+
+// AWSAuth implements Vault authentication via the AWS IAM auth method,
+// letting an EC2 instance or EKS pod with an attached IAM role authenticate
+// without any local credential material at all.
+// See https://www.vaultproject.io/docs/auth/aws
+type AWSAuth struct {
+	mountPath string
+	role      string
+}
+
+// awsAuthOptions configures a new AWSAuth
+type awsAuthOptions struct {
+	mountPath string
+	role      string
+}
+
+// AWSAuthOption configures an AWSAuth using the functional options
+// paradigm used throughout this package.
+type AWSAuthOption interface {
+	apply(o *awsAuthOptions)
+}
+
+// AWSAuthMountPath is the mount path of the AWS auth method
+type AWSAuthMountPath string
+
+func (co AWSAuthMountPath) apply(o *awsAuthOptions) {
+	o.mountPath = string(co)
+}
+
+// AWSAuthRole is the Vault role to authenticate as
+type AWSAuthRole string
+
+func (co AWSAuthRole) apply(o *awsAuthOptions) {
+	o.role = string(co)
+}
+
+// NewAWSAuthWithOptions creates a new AWSAuth with custom options.
+func NewAWSAuthWithOptions(opts ...AWSAuthOption) (*AWSAuth, error) {
+	o := &awsAuthOptions{}
+
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = viper.GetString("vault-auth-path")
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = defaultAWSMountPath
+	}
+
+	if o.role == "" {
+		o.role = viper.GetString("vault-role")
+	}
+
+	if o.role == "" {
+		return nil, fmt.Errorf("failed to determine the Vault role for AWS authentication")
+	}
+
+	return &AWSAuth{mountPath: o.mountPath, role: o.role}, nil
+}
+
+// Login authenticates against Vault using the IAM auth type, signing a
+// GetCallerIdentity request with the credentials found in the instance's
+// or pod's default AWS credential chain.
+func (a *AWSAuth) Login(ctx context.Context, client *vaultapi.Client) (*vaultapi.Secret, error) {
+	auth, err := awsauth.NewAWSAuth(
+		awsauth.WithRole(a.role),
+		awsauth.WithMountPath(a.mountPath),
+		awsauth.WithIAMAuth(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build AWS authenticator: %s", err)
+	}
+
+	return auth.Login(ctx, client)
+}
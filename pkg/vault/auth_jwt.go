@@ -0,0 +1,121 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+)
+
+// defaultJWTMountPath is Vault's conventional mount path for the JWT/OIDC
+// auth method, used when "vault-auth-path" is left unset.
+const defaultJWTMountPath = "jwt"
+
+// JWTAuth implements Vault authentication via the generic JWT/OIDC auth
+// method. Unlike Kubernetes and AWS IAM auth, this method does not assume
+// anything about where the JWT comes from, so it is read from a file that
+// some external process (e.g. an OIDC identity provider, a CI system's own
+// id-token mechanism) is expected to keep up to date.
+// See https://www.vaultproject.io/docs/auth/jwt
+type JWTAuth struct {
+	mountPath string
+	role      string
+	tokenPath string
+}
+
+// jwtAuthOptions configures a new JWTAuth
+type jwtAuthOptions struct {
+	mountPath string
+	role      string
+	tokenPath string
+}
+
+// JWTAuthOption configures a JWTAuth using the functional options paradigm
+// used throughout this package.
+type JWTAuthOption interface {
+	apply(o *jwtAuthOptions)
+}
+
+// JWTAuthMountPath is the mount path of the JWT auth method
+type JWTAuthMountPath string
+
+func (co JWTAuthMountPath) apply(o *jwtAuthOptions) {
+	o.mountPath = string(co)
+}
+
+// JWTAuthRole is the Vault role to authenticate as
+type JWTAuthRole string
+
+func (co JWTAuthRole) apply(o *jwtAuthOptions) {
+	o.role = string(co)
+}
+
+// JWTAuthTokenPath is the path to the file holding the JWT to present to Vault
+type JWTAuthTokenPath string
+
+func (co JWTAuthTokenPath) apply(o *jwtAuthOptions) {
+	o.tokenPath = string(co)
+}
+
+// NewJWTAuthWithOptions creates a new JWTAuth with custom options.
+func NewJWTAuthWithOptions(opts ...JWTAuthOption) (*JWTAuth, error) {
+	o := &jwtAuthOptions{}
+
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = viper.GetString("vault-auth-path")
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = defaultJWTMountPath
+	}
+
+	if o.role == "" {
+		o.role = viper.GetString("vault-role")
+	}
+
+	if o.tokenPath == "" {
+		o.tokenPath = viper.GetString("vault-jwt-path")
+	}
+
+	if o.role == "" {
+		return nil, fmt.Errorf("failed to determine the Vault role for JWT authentication")
+	}
+
+	if o.tokenPath == "" {
+		return nil, fmt.Errorf("failed to determine the JWT token path for JWT authentication")
+	}
+
+	return &JWTAuth{mountPath: o.mountPath, role: o.role, tokenPath: o.tokenPath}, nil
+}
+
+// Login authenticates against Vault's JWT/OIDC auth method by reading a
+// fresh JWT from jwt.tokenPath and presenting it for the configured role.
+func (j *JWTAuth) Login(ctx context.Context, client *vaultapi.Client) (*vaultapi.Secret, error) {
+	token, err := ioutil.ReadFile(j.tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT from %s: %s", j.tokenPath, err)
+	}
+
+	payload := map[string]interface{}{"role": j.role, "jwt": string(token)}
+	return client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", j.mountPath), payload)
+}
@@ -0,0 +1,110 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	kubeauth "github.com/hashicorp/vault/api/auth/kubernetes"
+	"github.com/spf13/viper"
+)
+
+// defaultServiceAccountTokenPath is where Kubernetes projects the pod's
+// service account JWT by default.
+const defaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// defaultKubernetesMountPath is Vault's conventional mount path for the
+// Kubernetes auth method, used when "vault-auth-path" is left unset.
+const defaultKubernetesMountPath = "kubernetes"
+
+// KubernetesAuth implements Vault authentication via the Kubernetes auth
+// method, using the pod's projected service account JWT. It lets the
+// broker run inside a Kubernetes cluster without ever seeing an AppRole
+// SecretID bootstrap file.
+// See https://www.vaultproject.io/docs/auth/kubernetes
+type KubernetesAuth struct {
+	mountPath string
+	role      string
+}
+
+// kubernetesAuthOptions configures a new KubernetesAuth
+type kubernetesAuthOptions struct {
+	mountPath string
+	role      string
+}
+
+// KubernetesAuthOption configures a KubernetesAuth using the functional
+// options paradigm used throughout this package.
+type KubernetesAuthOption interface {
+	apply(o *kubernetesAuthOptions)
+}
+
+// KubernetesAuthMountPath is the mount path of the Kubernetes auth method
+type KubernetesAuthMountPath string
+
+func (co KubernetesAuthMountPath) apply(o *kubernetesAuthOptions) {
+	o.mountPath = string(co)
+}
+
+// KubernetesAuthRole is the Vault role to authenticate as
+type KubernetesAuthRole string
+
+func (co KubernetesAuthRole) apply(o *kubernetesAuthOptions) {
+	o.role = string(co)
+}
+
+// NewKubernetesAuthWithOptions creates a new KubernetesAuth with custom options.
+func NewKubernetesAuthWithOptions(opts ...KubernetesAuthOption) (*KubernetesAuth, error) {
+	o := &kubernetesAuthOptions{}
+
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = viper.GetString("vault-auth-path")
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = defaultKubernetesMountPath
+	}
+
+	if o.role == "" {
+		o.role = viper.GetString("vault-role")
+	}
+
+	if o.role == "" {
+		return nil, fmt.Errorf("failed to determine the Vault role for Kubernetes authentication")
+	}
+
+	return &KubernetesAuth{mountPath: o.mountPath, role: o.role}, nil
+}
+
+// Login authenticates against Vault using the service account JWT
+// Kubernetes projects into the pod.
+func (k *KubernetesAuth) Login(ctx context.Context, client *vaultapi.Client) (*vaultapi.Secret, error) {
+	auth, err := kubeauth.NewKubernetesAuth(
+		k.role,
+		kubeauth.WithMountPath(k.mountPath),
+		kubeauth.WithServiceAccountTokenPath(defaultServiceAccountTokenPath),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Kubernetes authenticator: %s", err)
+	}
+
+	return auth.Login(ctx, client)
+}
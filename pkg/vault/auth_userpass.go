@@ -0,0 +1,132 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	userpassauth "github.com/hashicorp/vault/api/auth/userpass"
+	"github.com/spf13/viper"
+)
+
+// defaultUserpassMountPath is Vault's conventional mount path for the
+// userpass auth method, used when "vault-auth-path" is left unset.
+const defaultUserpassMountPath = "userpass"
+
+// UserpassAuth implements Vault authentication via the userpass auth
+// method. It exists mostly for local development and for environments that
+// cannot offer any of the other, credential-less auth methods; the password
+// is read from a file rather than taken as a flag or environment variable
+// to avoid leaking it into process listings or shell history.
+// See https://www.vaultproject.io/docs/auth/userpass
+type UserpassAuth struct {
+	mountPath    string
+	username     string
+	passwordPath string
+}
+
+// userpassAuthOptions configures a new UserpassAuth
+type userpassAuthOptions struct {
+	mountPath    string
+	username     string
+	passwordPath string
+}
+
+// UserpassAuthOption configures a UserpassAuth using the functional
+// options paradigm used throughout this package.
+type UserpassAuthOption interface {
+	apply(o *userpassAuthOptions)
+}
+
+// UserpassAuthMountPath is the mount path of the userpass auth method
+type UserpassAuthMountPath string
+
+func (co UserpassAuthMountPath) apply(o *userpassAuthOptions) {
+	o.mountPath = string(co)
+}
+
+// UserpassAuthUsername is the username to authenticate as
+type UserpassAuthUsername string
+
+func (co UserpassAuthUsername) apply(o *userpassAuthOptions) {
+	o.username = string(co)
+}
+
+// UserpassAuthPasswordPath is the path to the file holding the password
+type UserpassAuthPasswordPath string
+
+func (co UserpassAuthPasswordPath) apply(o *userpassAuthOptions) {
+	o.passwordPath = string(co)
+}
+
+// NewUserpassAuthWithOptions creates a new UserpassAuth with custom options.
+func NewUserpassAuthWithOptions(opts ...UserpassAuthOption) (*UserpassAuth, error) {
+	o := &userpassAuthOptions{}
+
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = viper.GetString("vault-auth-path")
+	}
+
+	if o.mountPath == "" {
+		o.mountPath = defaultUserpassMountPath
+	}
+
+	if o.username == "" {
+		o.username = viper.GetString("vault-userpass-username")
+	}
+
+	if o.passwordPath == "" {
+		o.passwordPath = viper.GetString("vault-userpass-password-path")
+	}
+
+	if o.username == "" {
+		return nil, fmt.Errorf("failed to determine the username for userpass authentication")
+	}
+
+	if o.passwordPath == "" {
+		return nil, fmt.Errorf("failed to determine the password path for userpass authentication")
+	}
+
+	return &UserpassAuth{mountPath: o.mountPath, username: o.username, passwordPath: o.passwordPath}, nil
+}
+
+// Login authenticates against Vault's userpass auth method, reading the
+// password fresh from disk on every call so a rotated password is picked
+// up without a restart.
+func (u *UserpassAuth) Login(ctx context.Context, client *vaultapi.Client) (*vaultapi.Secret, error) {
+	password, err := ioutil.ReadFile(u.passwordPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read userpass password from %s: %s", u.passwordPath, err)
+	}
+
+	auth, err := userpassauth.NewUserpassAuth(
+		u.username,
+		&userpassauth.Password{FromString: strings.TrimSpace(string(password))},
+		userpassauth.WithMountPath(u.mountPath),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build userpass authenticator: %s", err)
+	}
+
+	return auth.Login(ctx, client)
+}
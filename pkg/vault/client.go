@@ -15,6 +15,7 @@
 package vault
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -22,6 +23,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bedag/vault-secret-broker/pkg/vault/metrics"
 	"github.com/hashicorp/vault/api"
 	vaultapi "github.com/hashicorp/vault/api"
 	log "github.com/sirupsen/logrus"
@@ -34,8 +36,9 @@ const (
 )
 
 type clientOptions struct {
-	role     string
-	authPath string
+	role          string
+	authPath      string
+	authenticator Authenticator
 }
 
 // ClientOption configures a Vault client using the functional options paradigm popularized by Rob Pike and Dave Cheney.
@@ -60,16 +63,41 @@ func (co ClientAuthPath) apply(o *clientOptions) {
 	o.authPath = string(co)
 }
 
+// clientAuthenticatorOption wraps an Authenticator so it can be passed to
+// NewClientWithOptions via WithAuthenticator.
+type clientAuthenticatorOption struct {
+	authenticator Authenticator
+}
+
+func (co clientAuthenticatorOption) apply(o *clientOptions) {
+	o.authenticator = co.authenticator
+}
+
+// WithAuthenticator selects the Authenticator the Client should use to log
+// in to Vault, overriding the "vault-auth-method" based default. This lets
+// operators running the broker inside a Kubernetes pod or on an EC2/EKS
+// node authenticate without ever dropping an AppRole SecretID bootstrap
+// file onto disk.
+func WithAuthenticator(authenticator Authenticator) ClientOption {
+	return clientAuthenticatorOption{authenticator: authenticator}
+}
+
 // Client manages the connection to the vault, especially refreshing of the
 // auth token
 type Client struct {
-	client       *vaultapi.Client
-	logical      *vaultapi.Logical
-	tokenRenewer *vaultapi.Renewer
-	closed       bool
-	watch        *fsnotify.Watcher
-	mu           sync.Mutex
-	approle      *Approle
+	client        *vaultapi.Client
+	logical       *vaultapi.Logical
+	leases        *LeaseManager
+	closed        bool
+	watch         *fsnotify.Watcher
+	mu            sync.Mutex
+	authenticator Authenticator
+
+	// otelTransport is the concrete *http.Transport underlying the
+	// otelhttp-wrapped config.HttpClient.Transport, kept around so the
+	// CA-cert reload watcher can temporarily restore it across calls to
+	// config.ReadEnvironment. See reloadEnvironmentWithOTel.
+	otelTransport *http.Transport
 }
 
 // NewClient creates a new Vault client.
@@ -84,6 +112,11 @@ func NewClientWithOptions(opts ...ClientOption) (*Client, error) {
 
 // NewClientFromConfig creates a new Vault client from custom configuration.
 func NewClientFromConfig(config *vaultapi.Config, opts ...ClientOption) (*Client, error) {
+	otelTransport, err := instrumentWithOTel(config)
+	if err != nil {
+		return nil, err
+	}
+
 	rawClient, err := vaultapi.NewClient(config)
 	if err != nil {
 		return nil, err
@@ -94,6 +127,8 @@ func NewClientFromConfig(config *vaultapi.Config, opts ...ClientOption) (*Client
 		return nil, err
 	}
 
+	client.otelTransport = otelTransport
+
 	caCertPath := os.Getenv(vaultapi.EnvVaultCACert)
 	caCertReload := os.Getenv("VAULT_CACERT_RELOAD") != "false"
 
@@ -122,7 +157,8 @@ func NewClientFromConfig(config *vaultapi.Config, opts ...ClientOption) (*Client
 					// we only care about the CA cert file or the Secret mount directory (if in Kubernetes)
 					if filepath.Clean(event.Name) == caCertFile || filepath.Base(event.Name) == "..data" {
 						if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-							err := config.ReadEnvironment()
+							err := reloadEnvironmentWithOTel(config, client.otelTransport)
+							metrics.CACertReloads.WithLabelValues(metrics.Outcome(err)).Inc()
 							if err != nil {
 								log.Error("failed to reload Vault config:", err)
 							} else {
@@ -145,7 +181,6 @@ func NewClientFromConfig(config *vaultapi.Config, opts ...ClientOption) (*Client
 // NewClientFromRawClient creates a new Vault client from custom raw client.
 func NewClientFromRawClient(rawClient *vaultapi.Client, opts ...ClientOption) (*Client, error) {
 	logical := rawClient.Logical()
-	var tokenRenewer *vaultapi.Renewer
 
 	o := &clientOptions{}
 
@@ -163,66 +198,78 @@ func NewClientFromRawClient(rawClient *vaultapi.Client, opts ...ClientOption) (*
 		o.authPath = viper.GetString("vault-auth-path")
 	}
 
-	approle, err := NewApprole()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create approle: %s", err)
+	authenticator := o.authenticator
+	if authenticator == nil {
+		var err error
+		authenticator, err = newDefaultAuthenticator(viper.GetString("vault-auth-method"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create authenticator: %s", err)
+		}
 	}
 
-	client := &Client{client: rawClient, logical: logical, approle: approle}
+	leases := NewLeaseManager(rawClient)
+	client := &Client{client: rawClient, logical: logical, authenticator: authenticator, leases: leases}
+
+	// login re-authenticates using the configured Authenticator. It is used
+	// both for the initial login and, later, as the LeaseManager's
+	// Refresher whenever the auth token can no longer be renewed.
+	login := func() (*vaultapi.Secret, error) {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+
+		// Login must be done while locked as some authenticators (e.g.
+		// Approle) change their own internal state by creating and
+		// storing a new secret id
+		return authenticator.Login(context.Background(), rawClient)
+	}
 
 	initialTokenArrived := make(chan string, 1)
-	initialTokenSent := false
 
 	go func() {
+		var secret *vaultapi.Secret
 		for {
 			client.mu.Lock()
 			if client.closed {
 				client.mu.Unlock()
-				break
+				return
 			}
-			// Login must be done while locked as the Login method
-			// changes the internal state of the approle instance
-			// by creating and storing a new secret id
-			secret, err := approle.Login(rawClient, o.authPath, o.role)
 			client.mu.Unlock()
 
-			if err != nil {
-				log.Info("Failed to request new Vault token", err.Error())
-				time.Sleep(1 * time.Second)
-				continue
+			var err error
+			secret, err = login()
+			if err == nil && secret != nil {
+				break
 			}
+			log.Info("Failed to request new Vault token, retrying: ", err)
+			time.Sleep(1 * time.Second)
+		}
 
-			if secret == nil {
-				log.Info("Received empty answer from Vault, retrying")
-				time.Sleep(1 * time.Second)
-				continue
-			}
+		log.Println("Received new Vault token")
 
-			log.Println("Received new Vault token")
+		if err := leases.AddToken(secret, time.Duration(secret.Auth.LeaseDuration)*time.Second, login); err != nil {
+			log.Error("Failed to register Vault token for renewal:", err.Error())
+		}
 
-			if !initialTokenSent {
-				initialTokenArrived <- secret.LeaseID
-				initialTokenSent = true
-			}
+		initialTokenArrived <- secret.Auth.ClientToken
+	}()
 
-			// Start the renewing process
-			tokenRenewer, err = rawClient.NewRenewer(&vaultapi.RenewerInput{Secret: secret})
-			if err != nil {
-				log.Info("Failed to renew Vault token", err.Error())
-				continue
+	go func() {
+		for event := range leases.Events() {
+			switch event.Type {
+			case EventRenewed:
+				log.Info("Renewed Vault lease ", event.LeaseID)
+			case EventRenewFailed:
+				log.Warn("Failed to renew Vault lease ", event.LeaseID, ": ", event.Err)
+			case EventRefreshed:
+				log.Info("Refreshed Vault lease ", event.LeaseID)
+			case EventRefreshFailed:
+				log.Error("Failed to refresh Vault lease ", event.LeaseID, ": ", event.Err)
 			}
-
-			client.mu.Lock()
-			client.tokenRenewer = tokenRenewer
-			client.mu.Unlock()
-
-			go tokenRenewer.Renew()
-
-			runRenewChecker(tokenRenewer)
 		}
-		log.Info("Vault token renewal closed")
 	}()
 
+	go leases.Run()
+
 	select {
 	case <-initialTokenArrived:
 		log.Info("Initial Vault token arrived")
@@ -235,20 +282,6 @@ func NewClientFromRawClient(rawClient *vaultapi.Client, opts ...ClientOption) (*
 	return client, nil
 }
 
-func runRenewChecker(tokenRenewer *vaultapi.Renewer) {
-	for {
-		select {
-		case err := <-tokenRenewer.DoneCh():
-			if err != nil {
-				log.Error("Vault token renewal error:", err.Error())
-			}
-			return
-		case <-tokenRenewer.RenewCh():
-			log.Info("Renewed Vault Token")
-		}
-	}
-}
-
 // RawClient returns the underlying raw Vault client.
 func (client *Client) RawClient() *vaultapi.Client {
 	return client.client
@@ -259,9 +292,10 @@ func (client *Client) Close() {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 
-	if client.tokenRenewer != nil {
-		client.closed = true
-		client.tokenRenewer.Stop()
+	client.closed = true
+
+	if client.leases != nil {
+		client.leases.Stop()
 	}
 
 	if client.watch != nil {
@@ -278,5 +312,9 @@ func NewRawClient() (*api.Client, error) {
 
 	config.HttpClient.Transport.(*http.Transport).TLSHandshakeTimeout = 5 * time.Second
 
+	if _, err := instrumentWithOTel(config); err != nil {
+		return nil, err
+	}
+
 	return vaultapi.NewClient(config)
 }
@@ -0,0 +1,72 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// TestClientConfigSupportsConfigureTLS guards against regressing into
+// wrapping a vaultapi.Config's HttpClient.Transport in a type other than
+// *http.Transport (e.g. an otelhttp.Transport): Config.ConfigureTLS (called
+// by ReadEnvironment, in turn called on every CA-cert hot-reload event in
+// NewClientFromConfig) does a hard type assertion to *http.Transport before
+// touching anything CA-cert related, and errors with "unsupported
+// HTTPClient transport type" if that assertion fails.
+func TestClientConfigSupportsConfigureTLS(t *testing.T) {
+	config := vaultapi.DefaultConfig()
+	if config.Error != nil {
+		t.Fatalf("DefaultConfig failed: %s", config.Error)
+	}
+
+	// the CA file doesn't need to exist: we only care that the transport
+	// type assertion is reached and passes, not that the rest of
+	// configureTLS succeeds.
+	err := config.ConfigureTLS(&vaultapi.TLSConfig{CACert: "testdata/does-not-exist.pem"})
+	if err != nil && strings.Contains(err.Error(), "unsupported HTTPClient transport type") {
+		t.Fatalf("config.HttpClient.Transport is no longer a *http.Transport: %s", err)
+	}
+}
+
+// TestReloadEnvironmentWithOTelSurvivesInstrumentation guards the otelhttp
+// instrumentation itself against the same regression: once instrumentWithOTel
+// has wrapped config.HttpClient.Transport, reloadEnvironmentWithOTel must
+// still be able to drive ConfigureTLS through config.ReadEnvironment.
+func TestReloadEnvironmentWithOTelSurvivesInstrumentation(t *testing.T) {
+	config := vaultapi.DefaultConfig()
+	if config.Error != nil {
+		t.Fatalf("DefaultConfig failed: %s", config.Error)
+	}
+
+	rawTransport, err := instrumentWithOTel(config)
+	if err != nil {
+		t.Fatalf("instrumentWithOTel failed: %s", err)
+	}
+
+	t.Setenv(vaultapi.EnvVaultCACert, "testdata/does-not-exist.pem")
+
+	err = reloadEnvironmentWithOTel(config, rawTransport)
+	if err != nil && strings.Contains(err.Error(), "unsupported HTTPClient transport type") {
+		t.Fatalf("reloadEnvironmentWithOTel did not restore the *http.Transport: %s", err)
+	}
+
+	if config.HttpClient.Transport == http.RoundTripper(rawTransport) {
+		t.Fatalf("reloadEnvironmentWithOTel left the raw transport in place instead of restoring the otelhttp wrapper")
+	}
+}
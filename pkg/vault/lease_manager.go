@@ -0,0 +1,361 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bedag/vault-secret-broker/pkg/vault/metrics"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// clock abstracts time so the LeaseManager's scheduling can be exercised
+// deterministically in tests, without sleeping for real.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// vaultRenewer abstracts the subset of the Vault API the LeaseManager uses
+// to renew tokens and leases, so tests can exercise renewNext's
+// renew-success and renew-failure branches against an in-memory stub
+// instead of a real Vault server.
+type vaultRenewer interface {
+	RenewToken(leaseID string, increment int) (*vaultapi.Secret, error)
+	RenewLease(leaseID string, increment int) (*vaultapi.Secret, error)
+}
+
+// realVaultRenewer is the production vaultRenewer, delegating straight to
+// the Vault SDK.
+type realVaultRenewer struct {
+	client *vaultapi.Client
+}
+
+func (r realVaultRenewer) RenewToken(leaseID string, increment int) (*vaultapi.Secret, error) {
+	return r.client.Auth().Token().RenewTokenAsSelf(leaseID, increment)
+}
+
+func (r realVaultRenewer) RenewLease(leaseID string, increment int) (*vaultapi.Secret, error) {
+	return r.client.Sys().Renew(leaseID, increment)
+}
+
+// renewAfterFraction is the fraction of a lease's duration after which it
+// is due for renewal, matching Vault's own "renew well before expiry"
+// guidance.
+const renewAfterFraction = 0.8
+
+// renewJitter bounds how far a lease's renewal is nudged earlier or later,
+// so many leases issued around the same time don't all renew in the same
+// instant and thunder against Vault.
+const renewJitter = 30 * time.Second
+
+// Refresher obtains a brand new secret to replace a lease that can no
+// longer be renewed, i.e. because it is not renewable or its renewal still
+// fails once its MaxTTL has passed.
+type Refresher func() (*vaultapi.Secret, error)
+
+// EventType identifies what happened to a managed lease.
+type EventType int
+
+// The possible lease lifecycle events published on a LeaseManager's
+// notification channel.
+const (
+	EventRenewed EventType = iota
+	EventRenewFailed
+	EventRefreshed
+	EventRefreshFailed
+)
+
+// Event is published on the LeaseManager's notification channel whenever a
+// managed lease is renewed, fails to renew, or is replaced via its
+// Refresher. HTTP handlers and metrics can subscribe to it via Events().
+type Event struct {
+	LeaseID string
+	Type    EventType
+	Err     error
+}
+
+// managedLease is an entry in the LeaseManager's renewal queue.
+type managedLease struct {
+	leaseID     string
+	isAuthToken bool
+	renewable   bool
+	issueTime   time.Time
+	duration    time.Duration
+	maxTTL      time.Duration
+	renewAfter  time.Time
+	refresher   Refresher
+
+	// index is maintained by container/heap and unused otherwise.
+	index int
+}
+
+// leaseQueue is a min-heap of managedLeases ordered by renewAfter.
+type leaseQueue []*managedLease
+
+func (q leaseQueue) Len() int           { return len(q) }
+func (q leaseQueue) Less(i, j int) bool { return q[i].renewAfter.Before(q[j].renewAfter) }
+func (q leaseQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *leaseQueue) Push(x interface{}) {
+	lease := x.(*managedLease)
+	lease.index = len(*q)
+	*q = append(*q, lease)
+}
+
+func (q *leaseQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	lease := old[n-1]
+	old[n-1] = nil
+	lease.index = -1
+	*q = old[:n-1]
+	return lease
+}
+
+// LeaseManager tracks an arbitrary number of Vault leases - the broker's
+// own auth token as well as any dynamic secret leases it eventually hands
+// out - and renews each shortly before it expires. A single background
+// goroutine pops the earliest-due lease off a min-heap, sleeps until its
+// renew-after time, renews it, and reinserts it with the new deadline, so
+// the cost of tracking N leases stays O(log N) per renewal instead of one
+// timer per lease.
+type LeaseManager struct {
+	renewer vaultRenewer
+	clock   clock
+
+	mu    sync.Mutex
+	queue leaseQueue
+	wake  chan struct{}
+
+	events chan Event
+	done   chan struct{}
+}
+
+// NewLeaseManager creates a LeaseManager that renews leases against client.
+func NewLeaseManager(client *vaultapi.Client) *LeaseManager {
+	return newLeaseManagerWithClock(realVaultRenewer{client: client}, realClock{})
+}
+
+func newLeaseManagerWithClock(renewer vaultRenewer, c clock) *LeaseManager {
+	return &LeaseManager{
+		renewer: renewer,
+		clock:   c,
+		wake:    make(chan struct{}, 1),
+		events:  make(chan Event, 100),
+		done:    make(chan struct{}),
+	}
+}
+
+// Events returns the channel on which renewal/refresh notifications are
+// published. The channel is never closed; callers should stop reading from
+// it once they call Stop.
+func (m *LeaseManager) Events() <-chan Event {
+	return m.events
+}
+
+// AddToken enqueues a freshly issued auth token (as returned by an
+// Authenticator) for renewal.
+func (m *LeaseManager) AddToken(secret *vaultapi.Secret, maxTTL time.Duration, refresher Refresher) error {
+	if secret == nil || secret.Auth == nil {
+		return fmt.Errorf("cannot manage a secret without an auth block")
+	}
+
+	return m.add(&managedLease{
+		leaseID:     secret.Auth.ClientToken,
+		isAuthToken: true,
+		renewable:   secret.Auth.Renewable,
+		duration:    time.Duration(secret.Auth.LeaseDuration) * time.Second,
+		maxTTL:      maxTTL,
+		refresher:   refresher,
+	})
+}
+
+// AddLease enqueues a dynamic secret lease (database credentials, PKI
+// certificates, ...) for renewal.
+func (m *LeaseManager) AddLease(secret *vaultapi.Secret, maxTTL time.Duration, refresher Refresher) error {
+	if secret == nil || secret.LeaseID == "" {
+		return fmt.Errorf("cannot manage a secret without a lease id")
+	}
+
+	return m.add(&managedLease{
+		leaseID:   secret.LeaseID,
+		renewable: secret.Renewable,
+		duration:  time.Duration(secret.LeaseDuration) * time.Second,
+		maxTTL:    maxTTL,
+		refresher: refresher,
+	})
+}
+
+func (m *LeaseManager) add(lease *managedLease) error {
+	lease.issueTime = m.clock.Now()
+	lease.renewAfter = m.renewAfterTime(lease.issueTime, lease.duration)
+
+	m.mu.Lock()
+	heap.Push(&m.queue, lease)
+	earliest := m.queue[0] == lease
+	m.mu.Unlock()
+
+	if earliest {
+		m.wakeUp()
+	}
+
+	return nil
+}
+
+func (m *LeaseManager) renewAfterTime(issueTime time.Time, duration time.Duration) time.Time {
+	jitter := time.Duration(rand.Int63n(int64(2*renewJitter))) - renewJitter
+	return issueTime.Add(time.Duration(float64(duration) * renewAfterFraction)).Add(jitter)
+}
+
+func (m *LeaseManager) wakeUp() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run starts the renewal loop and blocks until Stop is called. Callers
+// should invoke it in its own goroutine.
+func (m *LeaseManager) Run() {
+	for {
+		m.mu.Lock()
+		var wait <-chan time.Time
+		if len(m.queue) > 0 {
+			wait = m.clock.After(m.queue[0].renewAfter.Sub(m.clock.Now()))
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-m.done:
+			return
+		case <-m.wake:
+			continue
+		case <-wait:
+			m.renewNext()
+		}
+	}
+}
+
+// Stop terminates the renewal loop started by Run.
+func (m *LeaseManager) Stop() {
+	close(m.done)
+}
+
+func (m *LeaseManager) renewNext() {
+	m.mu.Lock()
+	if len(m.queue) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	lease := heap.Pop(&m.queue).(*managedLease)
+	m.mu.Unlock()
+
+	if !lease.renewable {
+		m.refresh(lease)
+		return
+	}
+
+	secret, err := m.renewLease(lease)
+	if err != nil {
+		metrics.LeaseRenewals.WithLabelValues(metrics.OutcomeFailure).Inc()
+		m.publish(Event{LeaseID: lease.leaseID, Type: EventRenewFailed, Err: err})
+
+		if lease.maxTTL == 0 || m.clock.Now().Before(lease.issueTime.Add(lease.maxTTL)) {
+			// still within MaxTTL: back off briefly and retry
+			lease.renewAfter = m.clock.Now().Add(renewJitter)
+			m.requeue(lease)
+			return
+		}
+
+		m.refresh(lease)
+		return
+	}
+
+	if secret != nil && lease.isAuthToken && secret.Auth != nil {
+		lease.duration = time.Duration(secret.Auth.LeaseDuration) * time.Second
+		lease.renewable = secret.Auth.Renewable
+	} else if secret != nil {
+		lease.duration = time.Duration(secret.LeaseDuration) * time.Second
+		lease.renewable = secret.Renewable
+	}
+	lease.renewAfter = m.renewAfterTime(m.clock.Now(), lease.duration)
+	m.requeue(lease)
+
+	metrics.LeaseRenewals.WithLabelValues(metrics.OutcomeSuccess).Inc()
+	metrics.LeaseTTLRemaining.WithLabelValues(metrics.LeaseKind(lease.isAuthToken)).Set(lease.duration.Seconds())
+	m.publish(Event{LeaseID: lease.leaseID, Type: EventRenewed})
+}
+
+func (m *LeaseManager) renewLease(lease *managedLease) (*vaultapi.Secret, error) {
+	if lease.isAuthToken {
+		return m.renewer.RenewToken(lease.leaseID, int(lease.duration.Seconds()))
+	}
+
+	return m.renewer.RenewLease(lease.leaseID, int(lease.duration.Seconds()))
+}
+
+func (m *LeaseManager) refresh(lease *managedLease) {
+	if lease.refresher == nil {
+		m.publish(Event{LeaseID: lease.leaseID, Type: EventRefreshFailed, Err: fmt.Errorf("lease %s expired and has no refresher", lease.leaseID)})
+		return
+	}
+
+	secret, err := lease.refresher()
+	if err != nil {
+		m.publish(Event{LeaseID: lease.leaseID, Type: EventRefreshFailed, Err: err})
+		return
+	}
+
+	if lease.isAuthToken {
+		_ = m.AddToken(secret, lease.maxTTL, lease.refresher)
+	} else {
+		_ = m.AddLease(secret, lease.maxTTL, lease.refresher)
+	}
+
+	m.publish(Event{LeaseID: lease.leaseID, Type: EventRefreshed})
+}
+
+func (m *LeaseManager) requeue(lease *managedLease) {
+	m.mu.Lock()
+	heap.Push(&m.queue, lease)
+	earliest := m.queue[0] == lease
+	m.mu.Unlock()
+
+	if earliest {
+		m.wakeUp()
+	}
+}
+
+func (m *LeaseManager) publish(event Event) {
+	select {
+	case m.events <- event:
+	default:
+		// the notification channel is a best-effort side channel; a slow
+		// or absent subscriber must never block lease renewal
+	}
+}
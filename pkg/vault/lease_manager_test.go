@@ -0,0 +1,205 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"container/heap"
+	"fmt"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// fakeClock is a controllable clock used to exercise the
+// LeaseManager's scheduling logic without sleeping for real.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// After fires immediately if d has already elapsed relative to c.now, and
+// never otherwise; tests advance c.now and re-check rather than waiting.
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+	}
+	return ch
+}
+
+func TestLeaseQueueOrdersByRenewAfter(t *testing.T) {
+	now := time.Now()
+	q := &leaseQueue{}
+
+	heap.Init(q)
+	heap.Push(q, &managedLease{leaseID: "c", renewAfter: now.Add(3 * time.Minute)})
+	heap.Push(q, &managedLease{leaseID: "a", renewAfter: now.Add(1 * time.Minute)})
+	heap.Push(q, &managedLease{leaseID: "b", renewAfter: now.Add(2 * time.Minute)})
+
+	var order []string
+	for q.Len() > 0 {
+		order = append(order, heap.Pop(q).(*managedLease).leaseID)
+	}
+
+	want := []string{"a", "b", "c"}
+	for i, id := range want {
+		if order[i] != id {
+			t.Fatalf("expected renewal order %v, got %v", want, order)
+		}
+	}
+}
+
+func TestRenewAfterTimeWithinJitterBounds(t *testing.T) {
+	m := newLeaseManagerWithClock(nil, &fakeClock{now: time.Now()})
+	issueTime := time.Now()
+	duration := 100 * time.Second
+
+	renewAfter := m.renewAfterTime(issueTime, duration)
+	expected := issueTime.Add(time.Duration(float64(duration) * renewAfterFraction))
+
+	delta := renewAfter.Sub(expected)
+	if delta < -renewJitter || delta > renewJitter {
+		t.Fatalf("renewAfter %v outside of +/-%v jitter window around %v", renewAfter, renewJitter, expected)
+	}
+}
+
+func TestLeaseManagerRefreshesNonRenewableLeaseOnExpiry(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	m := newLeaseManagerWithClock(nil, clk)
+
+	refreshed := false
+	refresher := func() (*vaultapi.Secret, error) {
+		refreshed = true
+		return &vaultapi.Secret{LeaseID: "new-lease", LeaseDuration: 60}, nil
+	}
+
+	if err := m.AddLease(&vaultapi.Secret{LeaseID: "old-lease", Renewable: false, LeaseDuration: 60}, 0, refresher); err != nil {
+		t.Fatalf("AddLease failed: %s", err)
+	}
+
+	m.renewNext()
+
+	if !refreshed {
+		t.Fatal("expected the refresher to be called for a non-renewable lease")
+	}
+
+	if got := len(m.queue); got != 1 {
+		t.Fatalf("expected the refreshed lease to be re-enqueued, queue has %d entries", got)
+	}
+
+	if m.queue[0].leaseID != "new-lease" {
+		t.Fatalf("expected re-enqueued lease id %q, got %q", "new-lease", m.queue[0].leaseID)
+	}
+}
+
+// fakeRenewer is an in-memory vaultRenewer stub: each call consumes the
+// next scripted response, so a test can drive renewNext through a
+// specific sequence of successes and failures.
+type fakeRenewer struct {
+	responses []fakeRenewResponse
+	calls     int
+}
+
+type fakeRenewResponse struct {
+	secret *vaultapi.Secret
+	err    error
+}
+
+func (f *fakeRenewer) next() (*vaultapi.Secret, error) {
+	if f.calls >= len(f.responses) {
+		return nil, fmt.Errorf("fakeRenewer: no more scripted responses")
+	}
+	resp := f.responses[f.calls]
+	f.calls++
+	return resp.secret, resp.err
+}
+
+func (f *fakeRenewer) RenewToken(leaseID string, increment int) (*vaultapi.Secret, error) {
+	return f.next()
+}
+
+func (f *fakeRenewer) RenewLease(leaseID string, increment int) (*vaultapi.Secret, error) {
+	return f.next()
+}
+
+func TestLeaseManagerRenewsRenewableLeaseOnSuccess(t *testing.T) {
+	clk := &fakeClock{now: time.Now()}
+	renewer := &fakeRenewer{responses: []fakeRenewResponse{
+		{secret: &vaultapi.Secret{LeaseID: "lease-1", Renewable: true, LeaseDuration: 120}},
+	}}
+	m := newLeaseManagerWithClock(renewer, clk)
+
+	if err := m.AddLease(&vaultapi.Secret{LeaseID: "lease-1", Renewable: true, LeaseDuration: 60}, 0, nil); err != nil {
+		t.Fatalf("AddLease failed: %s", err)
+	}
+
+	m.renewNext()
+
+	if renewer.calls != 1 {
+		t.Fatalf("expected exactly one renewal call, got %d", renewer.calls)
+	}
+
+	if got := len(m.queue); got != 1 {
+		t.Fatalf("expected the renewed lease to be re-enqueued, queue has %d entries", got)
+	}
+
+	if m.queue[0].duration != 120*time.Second {
+		t.Fatalf("expected renewed lease duration to be updated to 120s, got %v", m.queue[0].duration)
+	}
+}
+
+func TestLeaseManagerRefreshesAfterRenewFailurePastMaxTTL(t *testing.T) {
+	now := time.Now()
+	clk := &fakeClock{now: now}
+	renewer := &fakeRenewer{responses: []fakeRenewResponse{
+		{err: fmt.Errorf("permission denied")},
+	}}
+	m := newLeaseManagerWithClock(renewer, clk)
+
+	refreshed := false
+	refresher := func() (*vaultapi.Secret, error) {
+		refreshed = true
+		return &vaultapi.Secret{LeaseID: "new-lease", LeaseDuration: 60}, nil
+	}
+
+	lease := &managedLease{leaseID: "old-lease", renewable: true, duration: 60 * time.Second, maxTTL: 30 * time.Second, issueTime: now}
+	m.queue = leaseQueue{lease}
+
+	// advance the clock past issueTime+maxTTL so the renew failure falls
+	// outside the retry window and must fall back to the Refresher
+	clk.now = now.Add(31 * time.Second)
+	lease.refresher = refresher
+
+	m.renewNext()
+
+	if !refreshed {
+		t.Fatal("expected the refresher to be called once the renew failure is past maxTTL")
+	}
+
+	if m.queue[0].leaseID != "new-lease" {
+		t.Fatalf("expected re-enqueued lease id %q, got %q", "new-lease", m.queue[0].leaseID)
+	}
+}
+
+func TestLeaseManagerPublishEventsNeverBlocks(t *testing.T) {
+	m := newLeaseManagerWithClock(nil, &fakeClock{now: time.Now()})
+
+	// fill the notification channel past capacity; publish must not block
+	for i := 0; i < cap(m.events)+10; i++ {
+		m.publish(Event{LeaseID: "x", Type: EventRenewed})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the Prometheus collectors shared by pkg/vault and
+// pkg/broker, registered on the default Prometheus registry so a single
+// "/metrics" handler picks all of them up.
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Outcome label values used across the counters below.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// LeaseTTLRemaining's "kind" label values.
+const (
+	LeaseKindToken = "token"
+	LeaseKindLease = "lease"
+)
+
+var (
+	// AppRoleLogins counts AppRole login attempts by outcome.
+	AppRoleLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vault_secret_broker",
+		Subsystem: "approle",
+		Name:      "logins_total",
+		Help:      "Total number of AppRole login attempts, by outcome.",
+	}, []string{"outcome"})
+
+	// AppRoleLoginDuration observes how long AppRole logins take.
+	AppRoleLoginDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "vault_secret_broker",
+		Subsystem: "approle",
+		Name:      "login_duration_seconds",
+		Help:      "Duration of AppRole login calls against Vault.",
+	})
+
+	// SecretIDRotations counts AppRole SecretID rotations by outcome.
+	SecretIDRotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vault_secret_broker",
+		Subsystem: "approle",
+		Name:      "secret_id_rotations_total",
+		Help:      "Total number of AppRole SecretID rotations, by outcome.",
+	}, []string{"outcome"})
+
+	// LeaseRenewals counts lease/token renewal attempts by outcome.
+	LeaseRenewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vault_secret_broker",
+		Subsystem: "lease",
+		Name:      "renewals_total",
+		Help:      "Total number of lease renewal attempts, by outcome.",
+	}, []string{"outcome"})
+
+	// LeaseTTLRemaining is updated with the remaining TTL of a managed
+	// lease every time it is successfully renewed. It is labeled by kind
+	// ("token" or "lease") rather than by lease id, since lease ids change
+	// on every refresh and would otherwise grow the series unbounded.
+	LeaseTTLRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vault_secret_broker",
+		Subsystem: "lease",
+		Name:      "ttl_remaining_seconds",
+		Help:      "Remaining TTL of the most recently renewed lease, by kind (token, lease).",
+	}, []string{"kind"})
+
+	// CACertReloads counts CA certificate reloads by outcome.
+	CACertReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vault_secret_broker",
+		Subsystem: "client",
+		Name:      "ca_cert_reloads_total",
+		Help:      "Total number of CA certificate reloads, by outcome.",
+	}, []string{"outcome"})
+
+	// BrokerRequestDuration observes broker HTTP endpoint latency by path
+	// and response status.
+	BrokerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vault_secret_broker",
+		Subsystem: "broker",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of broker HTTP endpoints, by path and status.",
+	}, []string{"path", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		AppRoleLogins,
+		AppRoleLoginDuration,
+		SecretIDRotations,
+		LeaseRenewals,
+		LeaseTTLRemaining,
+		CACertReloads,
+		BrokerRequestDuration,
+	)
+}
+
+// Outcome turns an error into the "success"/"failure" label value used by
+// the counters above.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// LeaseKind turns whether a managed lease is the broker's own auth token
+// into the "kind" label value used by LeaseTTLRemaining.
+func LeaseKind(isAuthToken bool) string {
+	if isAuthToken {
+		return LeaseKindToken
+	}
+	return LeaseKindLease
+}
@@ -0,0 +1,69 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"net/http"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// instrumentWithOTel wraps config's HTTP transport with an OpenTelemetry
+// round-tripper so every Vault API call becomes a span. Since the Vault SDK
+// threads the caller's context.Context through to the underlying
+// http.Request, a trace context present on the context passed to e.g.
+// Logical().ReadWithContext is automatically propagated onto the outbound
+// request.
+//
+// config.HttpClient.Transport must be a concrete *http.Transport - that's
+// what vaultapi.Config.ConfigureTLS (and ReadEnvironment, which calls it on
+// every CA-cert hot-reload) type-asserts it to before touching anything
+// CA-cert related. instrumentWithOTel therefore returns the unwrapped
+// *http.Transport alongside the error: callers that reload config's
+// environment after instrumenting it (see reloadEnvironmentWithOTel) need it
+// back in place for the duration of that call.
+func instrumentWithOTel(config *vaultapi.Config) (*http.Transport, error) {
+	transport, ok := config.HttpClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unsupported HTTPClient transport type %T, expected *http.Transport", config.HttpClient.Transport)
+	}
+
+	config.HttpClient.Transport = otelhttp.NewTransport(
+		transport,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "vault " + r.Method + " " + r.URL.Path
+		}),
+	)
+
+	return transport, nil
+}
+
+// reloadEnvironmentWithOTel calls config.ReadEnvironment (e.g. on a CA-cert
+// hot-reload event) with config.HttpClient.Transport temporarily swapped
+// back to rawTransport, the *http.Transport returned by an earlier
+// instrumentWithOTel call, so ReadEnvironment's underlying ConfigureTLS call
+// finds the concrete type it expects. The otelhttp-wrapped transport is
+// restored before returning.
+func reloadEnvironmentWithOTel(config *vaultapi.Config, rawTransport *http.Transport) error {
+	instrumented := config.HttpClient.Transport
+	config.HttpClient.Transport = rawTransport
+	defer func() {
+		config.HttpClient.Transport = instrumented
+	}()
+
+	return config.ReadEnvironment()
+}
@@ -0,0 +1,25 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+// SecretIDStore persists an AppRole SecretID across restarts of the
+// broker. Load returns the most recently stored SecretID (or an error if
+// none has been stored yet); Store durably replaces it. Approle calls
+// Store whenever its SecretID changes: once when it is created, and again
+// after every successful login, when it requests a fresh SecretID.
+type SecretIDStore interface {
+	Load() (string, error)
+	Store(id string) error
+}
@@ -0,0 +1,134 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// AgeSecretIDStore is a SecretIDStore that encrypts the SecretID at rest
+// with age (https://age-encryption.org) before writing it to Path, so the
+// file is useless to anyone who doesn't also hold the decryption identity.
+// Writes go through the same temp-file-plus-rename dance as
+// FileSecretIDStore.
+type AgeSecretIDStore struct {
+	Path      string
+	identity  age.Identity
+	recipient age.Recipient
+}
+
+// NewAgeSecretIDStore creates an AgeSecretIDStore persisting the
+// age-encrypted SecretID at path, encrypting to and decrypting with
+// identityStr (an age X25519 identity, i.e. a line starting with
+// "AGE-SECRET-KEY-1...").
+func NewAgeSecretIDStore(path, identityStr string) (*AgeSecretIDStore, error) {
+	identity, err := age.ParseX25519Identity(strings.TrimSpace(identityStr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse age identity: %s", err)
+	}
+
+	return &AgeSecretIDStore{Path: path, identity: identity, recipient: identity.Recipient()}, nil
+}
+
+// NewAgeSecretIDStoreFromEnv creates an AgeSecretIDStore the same way as
+// NewAgeSecretIDStore, but reads the age identity from the environment
+// variable named envVar, or - if that is unset - from the file at
+// identityPath (e.g. a Kubernetes Secret mounted into the pod). This keeps
+// the decryption key out of the broker's configuration file and command
+// line.
+func NewAgeSecretIDStoreFromEnv(path, envVar, identityPath string) (*AgeSecretIDStore, error) {
+	identityStr := os.Getenv(envVar)
+	if identityStr == "" {
+		data, err := ioutil.ReadFile(identityPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load age identity from %q or file %q: %s", envVar, identityPath, err)
+		}
+		identityStr = string(data)
+	}
+
+	return NewAgeSecretIDStore(path, identityStr)
+}
+
+// Load reads and decrypts the SecretID stored at Path.
+func (s *AgeSecretIDStore) Load() (string, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(data), s.identity)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt SecretID: %s", err)
+	}
+
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read decrypted SecretID: %s", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// Store encrypts id to recipient and atomically replaces Path with the
+// result.
+func (s *AgeSecretIDStore) Store(id string) error {
+	buf := &bytes.Buffer{}
+
+	w, err := age.Encrypt(buf, s.recipient)
+	if err != nil {
+		return fmt.Errorf("failed to initialize age encryption: %s", err)
+	}
+
+	if _, err := w.Write([]byte(id)); err != nil {
+		return fmt.Errorf("failed to encrypt SecretID: %s", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finalize encrypted SecretID: %s", err)
+	}
+
+	tmpPath := s.Path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary encrypted SecretID file: %s", err)
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temporary encrypted SecretID file: %s", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to fsync temporary encrypted SecretID file: %s", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary encrypted SecretID file: %s", err)
+	}
+
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		return fmt.Errorf("failed to atomically replace encrypted SecretID file: %s", err)
+	}
+
+	return nil
+}
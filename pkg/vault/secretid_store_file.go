@@ -0,0 +1,78 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// FileSecretIDStore is a SecretIDStore backed by a single file at Path. To
+// avoid leaving a truncated or corrupt SecretID behind if the process
+// crashes mid-write, Store writes the new value to a temporary file in the
+// same directory, fsyncs it, and only then renames it over Path - an
+// operation atomic on the same filesystem.
+type FileSecretIDStore struct {
+	Path string
+}
+
+// NewFileSecretIDStore creates a FileSecretIDStore persisting the SecretID
+// at path.
+func NewFileSecretIDStore(path string) *FileSecretIDStore {
+	return &FileSecretIDStore{Path: path}
+}
+
+// Load reads the SecretID currently stored at Path.
+func (s *FileSecretIDStore) Load() (string, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// Store writes id to a "Path.tmp" sibling file, fsyncs it, and renames it
+// over Path, so a crash between the two never leaves a partially written
+// or missing SecretID on disk.
+func (s *FileSecretIDStore) Store(id string) error {
+	tmpPath := s.Path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary SecretID file: %s", err)
+	}
+
+	if _, err := f.WriteString(id); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temporary SecretID file: %s", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to fsync temporary SecretID file: %s", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary SecretID file: %s", err)
+	}
+
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		return fmt.Errorf("failed to atomically replace SecretID file: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// secretIDDataKey is the key under which the SecretID is stored in the
+// backing Kubernetes Secret's Data map.
+const secretIDDataKey = "secret_id"
+
+// maxSecretIDStoreConflictRetries bounds the number of times
+// KubernetesSecretIDStore.Store retries a write that lost a
+// resourceVersion race against another replica.
+const maxSecretIDStoreConflictRetries = 5
+
+// KubernetesSecretIDStore is a SecretIDStore backed by a Kubernetes Secret,
+// letting multiple broker replicas in the same namespace read and write a
+// single stored AppRole SecretID. It does not coordinate rotation between
+// replicas: each replica's Approle still rotates the SecretID on its own
+// logins, and the last successful Store wins. Writes retry on a
+// resourceVersion conflict: a replica that loses the race re-reads the
+// Secret and writes its own value again.
+type KubernetesSecretIDStore struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+}
+
+// NewKubernetesSecretIDStoreInCluster creates a KubernetesSecretIDStore
+// using the pod's in-cluster service account to read and write the Secret
+// named name in namespace.
+func NewKubernetesSecretIDStoreInCluster(namespace, name string) (*KubernetesSecretIDStore, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster Kubernetes config: %s", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %s", err)
+	}
+
+	return &KubernetesSecretIDStore{clientset: clientset, namespace: namespace, name: name}, nil
+}
+
+// Load returns the SecretID currently stored in the backing Secret.
+func (s *KubernetesSecretIDStore) Load() (string, error) {
+	secret, err := s.clientset.CoreV1().Secrets(s.namespace).Get(context.Background(), s.name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	secretID, ok := secret.Data[secretIDDataKey]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no %q key", s.namespace, s.name, secretIDDataKey)
+	}
+
+	return string(secretID), nil
+}
+
+// Store writes id into the backing Secret, creating it if it doesn't
+// exist yet. If another replica updates the Secret concurrently, the
+// Update call is rejected for a stale resourceVersion; Store re-reads the
+// Secret and retries up to maxSecretIDStoreConflictRetries times.
+func (s *KubernetesSecretIDStore) Store(id string) error {
+	secrets := s.clientset.CoreV1().Secrets(s.namespace)
+
+	for attempt := 0; attempt < maxSecretIDStoreConflictRetries; attempt++ {
+		existing, err := secrets.Get(context.Background(), s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = secrets.Create(context.Background(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+				Data:       map[string][]byte{secretIDDataKey: []byte(id)},
+			}, metav1.CreateOptions{})
+			if err == nil {
+				return nil
+			}
+			if apierrors.IsAlreadyExists(err) {
+				continue
+			}
+			return fmt.Errorf("failed to create SecretID secret %s/%s: %s", s.namespace, s.name, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read SecretID secret %s/%s: %s", s.namespace, s.name, err)
+		}
+
+		if existing.Data == nil {
+			existing.Data = map[string][]byte{}
+		}
+		existing.Data[secretIDDataKey] = []byte(id)
+
+		_, err = secrets.Update(context.Background(), existing, metav1.UpdateOptions{})
+		if err == nil {
+			return nil
+		}
+		if apierrors.IsConflict(err) {
+			continue
+		}
+		return fmt.Errorf("failed to update SecretID secret %s/%s: %s", s.namespace, s.name, err)
+	}
+
+	return fmt.Errorf("failed to update SecretID secret %s/%s after %d attempts due to repeated resourceVersion conflicts", s.namespace, s.name, maxSecretIDStoreConflictRetries)
+}
@@ -0,0 +1,59 @@
+// Copyright © 2019 Michael Gruener
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemorySecretIDStore is a SecretIDStore that never touches disk: it keeps
+// the rotated SecretID in memory only, so it is lost whenever the process
+// exits. It is intended for ephemeral pods that obtain a fresh initial
+// SecretID on every start (e.g. combined with Kubernetes auth as the
+// bootstrap mechanism instead of an AppRole), where persisting a rotated
+// SecretID across restarts is neither possible nor desired.
+type MemorySecretIDStore struct {
+	mu sync.RWMutex
+	id string
+}
+
+// NewMemorySecretIDStore creates an empty MemorySecretIDStore.
+func NewMemorySecretIDStore() *MemorySecretIDStore {
+	return &MemorySecretIDStore{}
+}
+
+// Load returns the most recently stored SecretID, or an error if Store has
+// never been called.
+func (s *MemorySecretIDStore) Load() (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.id == "" {
+		return "", fmt.Errorf("no SecretID has been stored yet")
+	}
+
+	return s.id, nil
+}
+
+// Store replaces the in-memory SecretID.
+func (s *MemorySecretIDStore) Store(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.id = id
+
+	return nil
+}